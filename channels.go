@@ -0,0 +1,250 @@
+package kalshi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// streamMessages subscribes with params and sends the payload of every
+// message of type msgType to feed, after passing it through convert.
+// Payloads are delivered according to the Delivery policy of the Feed. Under
+// Conflate, payloads with the same key replace each other; a nil key means
+// every payload matters, and they are queued as with DropOldest.
+func streamMessages[W any, T any](
+	ctx context.Context, s *Feed,
+	params commandParams, sequenced bool,
+	msgType string, convert func(W) T, key func(T) string, feed chan<- T,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	send := deliver(ctx, s, feed, key)
+	return streamFunc(ctx, s, params, sequenced, msgType, convert, send)
+}
+
+// streamFunc is like streamMessages, but passes every payload to emit on
+// the reader goroutine.
+func streamFunc[W any, T any](
+	ctx context.Context, s *Feed,
+	params commandParams, sequenced bool,
+	msgType string, convert func(W) T, emit func(T),
+) error {
+	stats := newStreamStats()
+	handle := func(header subscriptionMessageHeader, msg []byte) error {
+		if header.Type != msgType {
+			return fmt.Errorf("unexpected type %q", header.Type)
+		}
+		var m W
+		start := time.Now()
+		if msg != nil {
+			err := json.Unmarshal(msg, &m)
+			if err != nil {
+				return fmt.Errorf("unmarshal %s: %w", msgType, err)
+			}
+		}
+		stats.decoded(start)
+		if et, ok := any(m).(exchangeTimer); ok {
+			stats.latency.observe(time.Since(et.exchangeTime()))
+		}
+		emit(convert(m))
+		return nil
+	}
+	return s.stream(ctx, params, subscriptionHandler{
+		sequenced: sequenced,
+		handle:    handle,
+		stats:     stats,
+	})
+}
+
+func identity[T any](v T) T {
+	return v
+}
+
+// Ticker is sent by the ticker channel whenever the price, top of book,
+// volume or open interest of a market changes.
+// Ticker is described here:
+// https://trading-api.readme.io/reference/ticker-channel.
+type Ticker struct {
+	MarketTicker       string    `json:"market_ticker"`
+	Price              Cents     `json:"price"`
+	YesBid             Cents     `json:"yes_bid"`
+	YesAsk             Cents     `json:"yes_ask"`
+	Volume             int       `json:"volume"`
+	OpenInterest       int       `json:"open_interest"`
+	DollarVolume       int       `json:"dollar_volume"`
+	DollarOpenInterest int       `json:"dollar_open_interest"`
+	Ts                 Timestamp `json:"ts"`
+}
+
+// Apply updates the market data of m with t, so that a Market fetched once
+// can be kept current without polling.
+func (t Ticker) Apply(m *Market) {
+	m.LastPrice = t.Price
+	m.YesBid = t.YesBid
+	m.YesAsk = t.YesAsk
+	// A Yes bid is a No ask of the complementary price and vice versa.
+	m.NoBid = 100 - t.YesAsk
+	m.NoAsk = 100 - t.YesBid
+	m.Volume = t.Volume
+	m.OpenInterest = t.OpenInterest
+}
+
+// Tickers streams ticker updates for marketTickers, or for every market if
+// none are given. Under Conflate, the latest ticker of each market is kept.
+func (s *Feed) Tickers(ctx context.Context, marketTickers []string, feed chan<- Ticker) error {
+	return streamMessages(ctx, s,
+		marketParams("ticker", marketTickers), false,
+		"ticker", identity[Ticker], func(t Ticker) string { return t.MarketTicker }, feed,
+	)
+}
+
+// tradeMessage is sent by the trade channel.
+// tradeMessage is described here:
+// https://trading-api.readme.io/reference/trade-channel.
+type tradeMessage struct {
+	TradeID      string    `json:"trade_id"`
+	MarketTicker string    `json:"market_ticker"`
+	YesPrice     Cents     `json:"yes_price"`
+	NoPrice      Cents     `json:"no_price"`
+	Count        int       `json:"count"`
+	TakerSide    Side      `json:"taker_side"`
+	Ts           Timestamp `json:"ts"`
+}
+
+func (m tradeMessage) Trade() Trade {
+	return Trade{
+		Count:       m.Count,
+		CreatedTime: m.Ts.Time(),
+		NoPrice:     m.NoPrice,
+		TakerSide:   m.TakerSide,
+		Ticker:      m.MarketTicker,
+		TradeID:     m.TradeID,
+		YesPrice:    m.YesPrice,
+	}
+}
+
+// Trades streams public trades for marketTickers, or for every market if
+// none are given. Trade messages usually carry no sequence number. Those
+// that do are checked, and a gap ends the stream with an error, just like
+// Book.
+func (s *Feed) Trades(ctx context.Context, marketTickers []string, feed chan<- Trade) error {
+	return streamMessages(ctx, s, marketParams("trade", marketTickers), false, "trade", tradeMessage.Trade, nil, feed)
+}
+
+// fillMessage is sent by the fill channel.
+// fillMessage is described here:
+// https://trading-api.readme.io/reference/fill-channel.
+type fillMessage struct {
+	TradeID      string      `json:"trade_id"`
+	OrderID      string      `json:"order_id"`
+	MarketTicker string      `json:"market_ticker"`
+	IsTaker      bool        `json:"is_taker"`
+	Side         Side        `json:"side"`
+	YesPrice     Cents       `json:"yes_price"`
+	NoPrice      Cents       `json:"no_price"`
+	Count        int         `json:"count"`
+	Action       OrderAction `json:"action"`
+	Ts           Timestamp   `json:"ts"`
+}
+
+func (m fillMessage) Fill() Fill {
+	noPrice := m.NoPrice
+	if noPrice == 0 && m.YesPrice != 0 {
+		noPrice = 100 - m.YesPrice
+	}
+	return Fill{
+		Action:      m.Action,
+		Count:       m.Count,
+		CreatedTime: m.Ts.Time(),
+		IsTaker:     m.IsTaker,
+		NoPrice:     noPrice,
+		OrderID:     m.OrderID,
+		Side:        m.Side,
+		Ticker:      m.MarketTicker,
+		TradeID:     m.TradeID,
+		YesPrice:    m.YesPrice,
+	}
+}
+
+// Fills streams fills of the authenticated user's orders in marketTickers,
+// or in every market if none are given. The Client that opened the Feed
+// must be logged in.
+func (s *Feed) Fills(ctx context.Context, marketTickers []string, feed chan<- Fill) error {
+	return streamMessages(ctx, s, marketParams("fill", marketTickers), false, "fill", fillMessage.Fill, nil, feed)
+}
+
+// Orders streams updates to the authenticated user's orders in
+// marketTickers, or in every market if none are given. Every update carries
+// the full state of the order. The Client that opened the Feed must be
+// logged in. Under Conflate, the latest update of each order is kept.
+func (s *Feed) Orders(ctx context.Context, marketTickers []string, feed chan<- Order) error {
+	return streamMessages(ctx, s,
+		marketParams("user_orders", marketTickers), false,
+		"user_order", identity[Order], func(o Order) string { return o.OrderID }, feed,
+	)
+}
+
+// LifecycleEventType is the kind of a MarketLifecycleEvent.
+type LifecycleEventType string
+
+const (
+	// MarketCreated is sent when a market is listed.
+	MarketCreated LifecycleEventType = "created"
+	// MarketActivated is sent when a market opens or resumes trading.
+	MarketActivated LifecycleEventType = "activated"
+	// MarketDeactivated is sent when trading in a market is paused.
+	MarketDeactivated LifecycleEventType = "deactivated"
+	// MarketCloseDateUpdated is sent when the close time of a market moves,
+	// such as when a market closes early.
+	MarketCloseDateUpdated LifecycleEventType = "close_date_updated"
+	// MarketDetermined is sent when the result of a market is known.
+	MarketDetermined LifecycleEventType = "determined"
+	// MarketSettled is sent when the positions in a market are paid out.
+	MarketSettled LifecycleEventType = "settled"
+)
+
+// MarketLifecycleEvent is sent by the market lifecycle channel when a market
+// changes state. Timestamps that don't apply to the event are nil.
+// MarketLifecycleEvent is described here:
+// https://trading-api.readme.io/reference/market-lifecycle-channel.
+type MarketLifecycleEvent struct {
+	MarketTicker string             `json:"market_ticker"`
+	EventType    LifecycleEventType `json:"event_type"`
+	// Result is one of "yes", "no" or empty if the market isn't determined.
+	Result          string     `json:"result,omitempty"`
+	OpenTs          *Timestamp `json:"open_ts,omitempty"`
+	CloseTs         *Timestamp `json:"close_ts,omitempty"`
+	DeterminationTs *Timestamp `json:"determination_ts,omitempty"`
+	SettledTs       *Timestamp `json:"settled_ts,omitempty"`
+}
+
+// Status returns the status of the market after the event, as reported in
+// Market.Status. It returns the empty string for events that don't change
+// the status.
+func (e MarketLifecycleEvent) Status() string {
+	switch e.EventType {
+	case MarketCreated:
+		return "initialized"
+	case MarketActivated:
+		return "open"
+	case MarketDeactivated:
+		return "paused"
+	case MarketDetermined:
+		return "determined"
+	case MarketSettled:
+		return "settled"
+	default:
+		return ""
+	}
+}
+
+// Lifecycle streams lifecycle events of marketTickers, or of every market if
+// none are given.
+func (s *Feed) Lifecycle(ctx context.Context, marketTickers []string, feed chan<- MarketLifecycleEvent) error {
+	return streamMessages(ctx, s,
+		marketParams("market_lifecycle_v2", marketTickers), false,
+		"market_lifecycle_v2", identity[MarketLifecycleEvent], nil, feed,
+	)
+}
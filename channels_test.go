@@ -0,0 +1,226 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+// acceptSubscription reads a subscribe command and acknowledges it with sid.
+func acceptSubscription(ctx context.Context, t *testing.T, c *websocket.Conn, sid int) command {
+	cmd := readCommand(ctx, t, c)
+	assert.Equal(t, "subscribe", cmd.Command)
+	writeMessages(ctx, t, c, fmt.Sprintf(
+		`{"id": %d, "type": "subscribed", "msg": {"channel": %q, "sid": %d}}`,
+		cmd.ID, cmd.Params.Channels[0], sid,
+	))
+	return cmd
+}
+
+func TestFeedTickers(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := acceptSubscription(ctx, t, c, 7)
+		assert.Equal(t, []string{"ticker"}, cmd.Params.Channels)
+		assert.Empty(t, cmd.Params.MarketTicker)
+		assert.Empty(t, cmd.Params.MarketTickers)
+		writeMessages(ctx, t, c,
+			`{"type": "ticker", "sid": 7, "msg": {"market_ticker": "DUH", "price": 48, "yes_bid": 45, "yes_ask": 53, "volume": 33896, "open_interest": 20422, "dollar_volume": 16948, "dollar_open_interest": 10211, "ts": 1669149841}}`,
+		)
+		<-ctx.Done()
+	})
+
+	tickers := make(chan Ticker)
+	go func() {
+		_ = f.Tickers(ctx, nil, tickers)
+	}()
+
+	tick := <-tickers
+	require.Equal(t, Ticker{
+		MarketTicker:       "DUH",
+		Price:              48,
+		YesBid:             45,
+		YesAsk:             53,
+		Volume:             33896,
+		OpenInterest:       20422,
+		DollarVolume:       16948,
+		DollarOpenInterest: 10211,
+		Ts:                 Timestamp(time.Unix(1669149841, 0)),
+	}, tick)
+
+	m := Market{Ticker: "DUH"}
+	tick.Apply(&m)
+	require.Equal(t, Cents(48), m.LastPrice)
+	require.Equal(t, Cents(47), m.NoBid)
+	require.Equal(t, Cents(55), m.NoAsk)
+	require.Equal(t, 20422, m.OpenInterest)
+}
+
+func TestFeedTrades(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const trade = `{"type": "trade", "sid": 3, "seq": %d, "msg": {"trade_id": "t%d", "market_ticker": "DUH", "yes_price": 36, "no_price": 64, "count": 136, "taker_side": "no", "ts": 1669149841}}`
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := acceptSubscription(ctx, t, c, 3)
+		assert.Equal(t, []string{"trade"}, cmd.Params.Channels)
+		assert.Equal(t, []string{"DUH", "DUH2"}, cmd.Params.MarketTickers)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(trade, 1, 1),
+			// Skips a sequence number.
+			fmt.Sprintf(trade, 3, 3),
+		)
+		<-ctx.Done()
+	})
+
+	var (
+		trades = make(chan Trade, 2)
+		err    = f.Trades(ctx, []string{"DUH", "DUH2"}, trades)
+	)
+	require.ErrorContains(t, err, "unexpected sequence 3, want 2")
+	require.Len(t, trades, 1)
+	require.Equal(t, Trade{
+		Count:       136,
+		CreatedTime: time.Unix(1669149841, 0),
+		NoPrice:     64,
+		TakerSide:   No,
+		Ticker:      "DUH",
+		TradeID:     "t1",
+		YesPrice:    36,
+	}, <-trades)
+}
+
+func TestFeedTradesUnsequenced(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The exchange sends trades without a sequence number.
+	const trade = `{"type": "trade", "sid": 3, "msg": {"trade_id": "t%d", "market_ticker": "DUH", "yes_price": 36, "no_price": 64, "count": 1, "taker_side": "no", "ts": 1669149841}}`
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		acceptSubscription(ctx, t, c, 3)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(trade, 1),
+			fmt.Sprintf(trade, 2),
+		)
+		<-ctx.Done()
+	})
+
+	trades := make(chan Trade)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- f.Trades(ctx, []string{"DUH"}, trades)
+	}()
+	for _, id := range []string{"t1", "t2"} {
+		select {
+		case trade := <-trades:
+			require.Equal(t, id, trade.TradeID)
+		case err := <-errs:
+			t.Fatalf("trades: %v", err)
+		}
+	}
+	require.Zero(t, f.Stats().SequenceGaps)
+}
+
+func TestFeedUserChannels(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		for i := 0; i < 2; i++ {
+			cmd := acceptSubscription(ctx, t, c, i+1)
+			switch cmd.Params.Channels[0] {
+			case "fill":
+				writeMessages(ctx, t, c, fmt.Sprintf(
+					`{"type": "fill", "sid": %d, "msg": {"trade_id": "t1", "order_id": "o1", "market_ticker": "DUH", "is_taker": true, "side": "yes", "yes_price": 75, "count": 278, "action": "buy", "ts": 1671899397}}`,
+					i+1,
+				))
+			case "user_orders":
+				writeMessages(ctx, t, c, fmt.Sprintf(
+					`{"type": "user_order", "sid": %d, "msg": {"order_id": "o1", "ticker": "DUH", "status": "executed", "side": "yes", "action": "buy", "yes_price": 75, "no_price": 25, "remaining_count": 0, "created_time": "2022-12-24T16:29:57Z"}}`,
+					i+1,
+				))
+			default:
+				t.Errorf("unexpected channel %q", cmd.Params.Channels[0])
+			}
+		}
+		<-ctx.Done()
+	})
+
+	var (
+		fills  = make(chan Fill)
+		orders = make(chan Order)
+	)
+	go func() {
+		_ = f.Fills(ctx, nil, fills)
+	}()
+	go func() {
+		_ = f.Orders(ctx, []string{"DUH"}, orders)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case fill := <-fills:
+			require.Equal(t, Fill{
+				Action:      Buy,
+				Count:       278,
+				CreatedTime: time.Unix(1671899397, 0),
+				IsTaker:     true,
+				NoPrice:     25,
+				OrderID:     "o1",
+				Side:        Yes,
+				Ticker:      "DUH",
+				TradeID:     "t1",
+				YesPrice:    75,
+			}, fill)
+		case order := <-orders:
+			require.Equal(t, "o1", order.OrderID)
+			require.Equal(t, Executed, order.Status)
+			require.Equal(t, Cents(75), order.Price())
+			require.Equal(t, 2022, order.CreatedTime.Year())
+		}
+	}
+}
+
+func TestFeedLifecycle(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		acceptSubscription(ctx, t, c, 1)
+		writeMessages(ctx, t, c,
+			`{"type": "market_lifecycle_v2", "sid": 1, "msg": {"market_ticker": "DUH", "event_type": "deactivated"}}`,
+			`{"type": "market_lifecycle_v2", "sid": 1, "msg": {"market_ticker": "DUH", "event_type": "determined", "result": "yes", "determination_ts": 1671899397}}`,
+		)
+		<-ctx.Done()
+	})
+
+	events := make(chan MarketLifecycleEvent)
+	go func() {
+		_ = f.Lifecycle(ctx, nil, events)
+	}()
+
+	ev := <-events
+	require.Equal(t, MarketDeactivated, ev.EventType)
+	require.Equal(t, "paused", ev.Status())
+	require.Nil(t, ev.DeterminationTs)
+
+	ev = <-events
+	require.Equal(t, MarketDetermined, ev.EventType)
+	require.Equal(t, "determined", ev.Status())
+	require.Equal(t, "yes", ev.Result)
+	require.Equal(t, time.Unix(1671899397, 0), ev.DeterminationTs.Time())
+}
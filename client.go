@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -58,6 +59,9 @@ type request struct {
 	QueryParams  any
 	JSONRequest  any
 	JSONResponse any
+	// Cost is the number of rate limit tokens the request takes. Zero
+	// means one.
+	Cost int
 }
 
 func jsonRequestHeaders(
@@ -136,6 +140,24 @@ func jsonRequestHeaders(
 	return nil
 }
 
+// ErrRateLimited is returned when a request would exceed the ReadRateLimit
+// or WriteRatelimit of its Client. Such requests are never sent.
+var ErrRateLimited = errors.New("ratelimit exceeded")
+
+type waitRateLimitKey struct{}
+
+// waitRateLimit returns a context whose requests wait for the rate limit
+// instead of failing when it is exceeded. It is meant for background
+// requests of a Feed, which would otherwise fail in bursts.
+func waitRateLimit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, waitRateLimitKey{}, true)
+}
+
+func rateLimitWaits(ctx context.Context) bool {
+	wait, _ := ctx.Value(waitRateLimitKey{}).(bool)
+	return wait
+}
+
 func (c *Client) request(
 	ctx context.Context, r request,
 ) error {
@@ -152,15 +174,30 @@ func (c *Client) request(
 		u.RawQuery = v.Encode()
 	}
 
+	cost := r.Cost
+	if cost == 0 {
+		cost = 1
+	}
 	// Do not block via Wait! Trades have to be
-	// fast to be meaningful!
+	// fast to be meaningful! Only background requests
+	// made through waitRateLimit wait.
 	if r.Method == "GET" {
-		if !c.ReadRateLimit.Allow() {
-			return fmt.Errorf("read ratelimit exceeded")
+		if rateLimitWaits(ctx) {
+			err = c.ReadRateLimit.WaitN(ctx, cost)
+			if err != nil {
+				return fmt.Errorf("read ratelimit: %w", err)
+			}
+		} else if !c.ReadRateLimit.AllowN(time.Now(), cost) {
+			return fmt.Errorf("read %w", ErrRateLimited)
 		}
 	} else {
-		if !c.WriteRatelimit.Allow() {
-			return fmt.Errorf("write ratelimit exceeded")
+		if rateLimitWaits(ctx) {
+			err = c.WriteRatelimit.WaitN(ctx, cost)
+			if err != nil {
+				return fmt.Errorf("write ratelimit: %w", err)
+			}
+		} else if !c.WriteRatelimit.AllowN(time.Now(), cost) {
+			return fmt.Errorf("write %w", ErrRateLimited)
 		}
 	}
 
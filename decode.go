@@ -0,0 +1,497 @@
+package kalshi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// Feed messages are decoded in a single pass by a scanner that works on the
+// message in place. decodeMessage splits a message into its header and its
+// payload, which is then decoded by the subscription it belongs to. Book
+// payloads are decoded without allocating, since they make up most of the
+// traffic of a Feed.
+
+// messageTypes interns the known message types, so that decoding their
+// header does not allocate.
+var messageTypes = func() map[string]string {
+	types := []string{
+		"subscribed", "unsubscribed", "ok", "error",
+		"orderbook_snapshot", "orderbook_delta",
+		"ticker", "trade", "fill", "user_order", "market_lifecycle_v2",
+	}
+	m := make(map[string]string, len(types))
+	for _, t := range types {
+		m[t] = t
+	}
+	return m
+}()
+
+func messageType(b []byte) string {
+	if t, ok := messageTypes[string(b)]; ok {
+		return t
+	}
+	return string(b)
+}
+
+// decodeMessage decodes the header of a feed message and returns its
+// payload, the value of its msg field. The payload points into message, and
+// is nil if the message has none.
+func decodeMessage(message []byte) (header subscriptionMessageHeader, msg []byte, err error) {
+	sc := scanner{b: message}
+	err = sc.object(func(key []byte) error {
+		var err error
+		switch string(key) {
+		case "id":
+			header.ID, err = sc.int()
+		case "type":
+			var t []byte
+			t, err = sc.string()
+			header.Type = messageType(t)
+		case "sid":
+			header.Sid, err = sc.int()
+		case "seq":
+			header.Seq, err = sc.int()
+		case "msg":
+			msg, err = sc.value()
+		default:
+			err = sc.skip()
+		}
+		return err
+	})
+	if err == nil {
+		err = sc.end()
+	}
+	if err != nil {
+		return header, nil, fmt.Errorf("decode message: %w", err)
+	}
+	return header, msg, nil
+}
+
+// bookDeltaMsg is the payload of an orderbook_delta message. Its tickers
+// point into the decoded payload.
+type bookDeltaMsg struct {
+	MarketID     []byte
+	MarketTicker []byte
+	Price        Cents
+	Delta        int
+	Side         Side
+}
+
+func (m *bookDeltaMsg) decode(b []byte) error {
+	*m = bookDeltaMsg{}
+	sc := scanner{b: b}
+	err := sc.object(func(key []byte) error {
+		var err error
+		switch string(key) {
+		case "market_id":
+			m.MarketID, err = sc.string()
+		case "market_ticker":
+			m.MarketTicker, err = sc.string()
+		case "price":
+			var price int
+			price, err = sc.int()
+			m.Price = Cents(price)
+		case "delta":
+			m.Delta, err = sc.int()
+		case "side":
+			var side []byte
+			side, err = sc.string()
+			m.Side = sideOf(side)
+		default:
+			err = sc.skip()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return sc.end()
+}
+
+// bookSnapshotMsg is the payload of an orderbook_snapshot message. Its
+// tickers point into the decoded payload, and its bids are reused by the
+// next decode.
+type bookSnapshotMsg struct {
+	MarketID     []byte
+	MarketTicker []byte
+	Yes          OrderBookBids
+	No           OrderBookBids
+}
+
+func (m *bookSnapshotMsg) decode(b []byte) error {
+	m.MarketID, m.MarketTicker = nil, nil
+	m.Yes, m.No = m.Yes[:0], m.No[:0]
+	sc := scanner{b: b}
+	err := sc.object(func(key []byte) error {
+		var err error
+		switch string(key) {
+		case "market_id":
+			m.MarketID, err = sc.string()
+		case "market_ticker":
+			m.MarketTicker, err = sc.string()
+		case "yes":
+			m.Yes, err = sc.bids(m.Yes)
+		case "no":
+			m.No, err = sc.bids(m.No)
+		default:
+			err = sc.skip()
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return sc.end()
+}
+
+func sideOf(b []byte) Side {
+	switch string(b) {
+	case "yes":
+		return Yes
+	case "no":
+		return No
+	default:
+		return Side(b)
+	}
+}
+
+// scanner reads JSON values from b in place. It understands the objects,
+// strings, integers and bid arrays of feed messages, and validates and skips
+// everything else. Nulls decode to zero values, as with encoding/json.
+// Unlike encoding/json, keys are matched exactly rather than
+// case-insensitively.
+type scanner struct {
+	b []byte
+	i int
+}
+
+func (s *scanner) errorf(format string, args ...any) error {
+	return fmt.Errorf("offset %d: %s", s.i, fmt.Sprintf(format, args...))
+}
+
+// peek skips whitespace and returns the next byte, or zero at the end of
+// the input.
+func (s *scanner) peek() byte {
+	for s.i < len(s.b) {
+		switch c := s.b[s.i]; c {
+		case ' ', '\t', '\r', '\n':
+			s.i++
+		default:
+			return c
+		}
+	}
+	return 0
+}
+
+func (s *scanner) consume(c byte) error {
+	if s.peek() != c {
+		return s.errorf("expected %q", c)
+	}
+	s.i++
+	return nil
+}
+
+// end fails if anything but whitespace follows.
+func (s *scanner) end() error {
+	if s.peek() != 0 {
+		return s.errorf("unexpected data after value")
+	}
+	return nil
+}
+
+// null consumes a null, if one is next.
+func (s *scanner) null() bool {
+	if s.peek() == 'n' && len(s.b)-s.i >= 4 && string(s.b[s.i:s.i+4]) == "null" {
+		s.i += 4
+		return true
+	}
+	return false
+}
+
+// object calls field for every key of an object, with the scanner
+// positioned at its value. field must consume the value.
+func (s *scanner) object(field func(key []byte) error) error {
+	if s.null() {
+		return nil
+	}
+	err := s.consume('{')
+	if err != nil {
+		return err
+	}
+	if s.peek() == '}' {
+		s.i++
+		return nil
+	}
+	for {
+		if s.peek() != '"' {
+			return s.errorf("expected key")
+		}
+		key, err := s.string()
+		if err != nil {
+			return err
+		}
+		err = s.consume(':')
+		if err != nil {
+			return err
+		}
+		err = field(key)
+		if err != nil {
+			return err
+		}
+		switch s.peek() {
+		case ',':
+			s.i++
+		case '}':
+			s.i++
+			return nil
+		default:
+			return s.errorf("expected ',' or '}'")
+		}
+	}
+}
+
+// rawString consumes a string and returns its contents as they appear in
+// the input, and whether they need decoding because they contain escapes or
+// invalid UTF-8. Escapes themselves are validated when decoded.
+func (s *scanner) rawString() (raw []byte, escaped bool, err error) {
+	err = s.consume('"')
+	if err != nil {
+		return nil, false, err
+	}
+	start := s.i
+	for ; s.i < len(s.b); s.i++ {
+		switch c := s.b[s.i]; {
+		case c == '\\':
+			escaped = true
+			s.i++
+		case c == '"':
+			raw = s.b[start:s.i]
+			s.i++
+			return raw, escaped || !utf8.Valid(raw), nil
+		case c < ' ':
+			return nil, false, s.errorf("invalid character %q in string", c)
+		}
+	}
+	return nil, false, s.errorf("unterminated string")
+}
+
+// string consumes a string. Strings that need no decoding point into the
+// input.
+func (s *scanner) string() ([]byte, error) {
+	if s.null() {
+		return nil, nil
+	}
+	s.peek()
+	begin := s.i
+	raw, escaped, err := s.rawString()
+	if err != nil || !escaped {
+		return raw, err
+	}
+	var str string
+	err = json.Unmarshal(s.b[begin:s.i], &str)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(str), nil
+}
+
+// int consumes an integer. Integers that don't fit an int fail, as with
+// encoding/json.
+func (s *scanner) int() (int, error) {
+	if s.null() {
+		return 0, nil
+	}
+	s.peek()
+	neg := s.i < len(s.b) && s.b[s.i] == '-'
+	if neg {
+		s.i++
+	}
+	limit := uint64(math.MaxInt)
+	if neg {
+		limit++
+	}
+	start := s.i
+	var n uint64
+	for ; s.i < len(s.b) && isDigit(s.b[s.i]); s.i++ {
+		d := uint64(s.b[s.i] - '0')
+		if n > (limit-d)/10 {
+			return 0, s.errorf("integer overflows int")
+		}
+		n = n*10 + d
+	}
+	switch {
+	case s.i == start:
+		return 0, s.errorf("expected integer")
+	case s.b[start] == '0' && s.i-start > 1:
+		return 0, s.errorf("integer with leading zero")
+	}
+	if s.i < len(s.b) {
+		switch s.b[s.i] {
+		case '.', 'e', 'E':
+			return 0, s.errorf("expected integer")
+		}
+	}
+	if neg {
+		return int(-n), nil
+	}
+	return int(n), nil
+}
+
+// bids consumes an array of [price, quantity] pairs and appends them to
+// dst.
+func (s *scanner) bids(dst OrderBookBids) (OrderBookBids, error) {
+	if s.null() {
+		return dst, nil
+	}
+	err := s.consume('[')
+	if err != nil {
+		return dst, err
+	}
+	if s.peek() == ']' {
+		s.i++
+		return dst, nil
+	}
+	for {
+		err = s.consume('[')
+		if err != nil {
+			return dst, err
+		}
+		price, err := s.int()
+		if err != nil {
+			return dst, err
+		}
+		err = s.consume(',')
+		if err != nil {
+			return dst, err
+		}
+		quantity, err := s.int()
+		if err != nil {
+			return dst, err
+		}
+		err = s.consume(']')
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, OrderBookBid{Price: Cents(price), Quantity: quantity})
+
+		switch s.peek() {
+		case ',':
+			s.i++
+		case ']':
+			s.i++
+			return dst, nil
+		default:
+			return dst, s.errorf("expected ',' or ']'")
+		}
+	}
+}
+
+// value consumes any value and returns it as it appears in the input.
+func (s *scanner) value() ([]byte, error) {
+	s.peek()
+	start := s.i
+	err := s.skip()
+	if err != nil {
+		return nil, err
+	}
+	return s.b[start:s.i], nil
+}
+
+// skip consumes and validates any value.
+func (s *scanner) skip() error {
+	switch c := s.peek(); {
+	case c == 0:
+		return s.errorf("unexpected end of input")
+	case c == '"':
+		_, err := s.string()
+		return err
+	case c == '{':
+		return s.object(func([]byte) error {
+			return s.skip()
+		})
+	case c == '[':
+		s.i++
+		if s.peek() == ']' {
+			s.i++
+			return nil
+		}
+		for {
+			err := s.skip()
+			if err != nil {
+				return err
+			}
+			switch s.peek() {
+			case ',':
+				s.i++
+			case ']':
+				s.i++
+				return nil
+			default:
+				return s.errorf("expected ',' or ']'")
+			}
+		}
+	case c == 't':
+		return s.literal("true")
+	case c == 'f':
+		return s.literal("false")
+	case c == 'n':
+		return s.literal("null")
+	case c == '-' || isDigit(c):
+		return s.number()
+	default:
+		return s.errorf("unexpected %q", c)
+	}
+}
+
+// literal consumes lit.
+func (s *scanner) literal(lit string) error {
+	if len(s.b)-s.i < len(lit) || string(s.b[s.i:s.i+len(lit)]) != lit {
+		return s.errorf("expected %s", lit)
+	}
+	s.i += len(lit)
+	return nil
+}
+
+// number consumes a number of any size.
+func (s *scanner) number() error {
+	if s.i < len(s.b) && s.b[s.i] == '-' {
+		s.i++
+	}
+	switch {
+	case s.i < len(s.b) && s.b[s.i] == '0':
+		s.i++
+	case s.digits() == 0:
+		return s.errorf("expected number")
+	}
+	if s.i < len(s.b) && s.b[s.i] == '.' {
+		s.i++
+		if s.digits() == 0 {
+			return s.errorf("expected fraction")
+		}
+	}
+	if s.i < len(s.b) && (s.b[s.i] == 'e' || s.b[s.i] == 'E') {
+		s.i++
+		if s.i < len(s.b) && (s.b[s.i] == '+' || s.b[s.i] == '-') {
+			s.i++
+		}
+		if s.digits() == 0 {
+			return s.errorf("expected exponent")
+		}
+	}
+	return nil
+}
+
+// digits consumes digits and returns how many there were.
+func (s *scanner) digits() int {
+	start := s.i
+	for s.i < len(s.b) && isDigit(s.b[s.i]) {
+		s.i++
+	}
+	return s.i - start
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
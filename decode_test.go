@@ -0,0 +1,573 @@
+package kalshi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_decodeMessage(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		name    string
+		message string
+		header  subscriptionMessageHeader
+		msg     string
+	}{
+		{
+			name:    "Delta",
+			message: `{"type": "orderbook_delta", "sid": 2, "seq": 7, "msg": {"market_ticker": "A", "price": 40, "delta": -3, "side": "yes"}}`,
+			header:  subscriptionMessageHeader{Type: "orderbook_delta", Sid: 2, Seq: 7},
+			msg:     `{"market_ticker": "A", "price": 40, "delta": -3, "side": "yes"}`,
+		},
+		{
+			name:    "Response",
+			message: `{"id":3,"type":"subscribed","msg":{"channel":"ticker","sid":1}}`,
+			header:  subscriptionMessageHeader{ID: 3, Type: "subscribed"},
+			msg:     `{"channel":"ticker","sid":1}`,
+		},
+		{
+			name:    "Skipped",
+			message: `{"extra": {"a": [1, "]}\"", {"b": null}], "c": true}, "msg": [1.5e3, "x"], "type": "custom", "seq": null}`,
+			header:  subscriptionMessageHeader{Type: "custom"},
+			msg:     `[1.5e3, "x"]`,
+		},
+		{
+			name:    "NoPayload",
+			message: `{"type": "orderbook_delta"}`,
+			header:  subscriptionMessageHeader{Type: "orderbook_delta"},
+		},
+		{
+			name:    "Limits",
+			message: fmt.Sprintf(`{"id": %d, "sid": %d, "x": [true, false, null, -0.5e+3, 0, "\u00e9"]}`, math.MaxInt, math.MinInt),
+			header:  subscriptionMessageHeader{ID: math.MaxInt, Sid: math.MinInt},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			header, msg, err := decodeMessage([]byte(tc.message))
+			require.NoError(t, err)
+			require.Equal(t, tc.header, header)
+			if tc.msg == "" {
+				require.Nil(t, msg)
+			} else {
+				require.Equal(t, tc.msg, string(msg))
+			}
+		})
+	}
+
+	for _, message := range []string{
+		``,
+		`[]`,
+		`{"type": "ok"`,
+		`{"type": "ok"} {}`,
+		`{"sid": 1.5}`,
+		`{"sid": "1"}`,
+		`{"msg": {"a": "b}`,
+		`{"type" "ok"}`,
+		`{"x": tru}`,
+		`{"x": nul}`,
+		`{"x": trueish}`,
+		`{"x": yes}`,
+		`{"x": 01}`,
+		`{"x": -}`,
+		`{"x": 1.}`,
+		`{"x": 1e}`,
+		`{"x": [1,]}`,
+		`{"x": [1 2]}`,
+		`{"x": {"a" 1}}`,
+		`{"x": {"a": 1,}}`,
+		`{"x": "\u00zz"}`,
+		"{\"x\": \"a\tb\"}",
+		`{"id": 00}`,
+		fmt.Sprintf(`{"id": %d0}`, math.MaxInt),
+		`{"sid": 99999999999999999999}`,
+	} {
+		_, _, err := decodeMessage([]byte(message))
+		require.Error(t, err, message)
+	}
+}
+
+func Test_decodeBookMsg(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Delta", func(t *testing.T) {
+		t.Parallel()
+		var m bookDeltaMsg
+		require.NoError(t, m.decode([]byte(`{"market_id": "X", "market_ticker": "A\"B", "price": 40, "delta": -3, "side": "no", "ts": "2023"}`)))
+		require.Equal(t, bookDeltaMsg{
+			MarketID:     []byte("X"),
+			MarketTicker: []byte(`A"B`),
+			Price:        40,
+			Delta:        -3,
+			Side:         No,
+		}, m)
+
+		// Decoding reuses nothing from the previous message.
+		require.NoError(t, m.decode([]byte(`{"price": 1, "delta": 1, "side": "yes"}`)))
+		require.Equal(t, bookDeltaMsg{Price: 1, Delta: 1, Side: Yes}, m)
+
+		require.Error(t, m.decode([]byte(`{"price": "40"}`)))
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		t.Parallel()
+		var m bookSnapshotMsg
+		require.NoError(t, m.decode([]byte(`{"market_ticker": "A", "yes": [[40, 10], [41, 5]], "no": null}`)))
+		require.Equal(t, "A", string(m.MarketTicker))
+		require.Equal(t, OrderBookBids{{40, 10}, {41, 5}}, m.Yes)
+		require.Empty(t, m.No)
+
+		require.NoError(t, m.decode([]byte(`{"market_ticker": "B", "yes": [], "no": [[60, 1]]}`)))
+		require.Equal(t, "B", string(m.MarketTicker))
+		require.Empty(t, m.Yes)
+		require.Equal(t, OrderBookBids{{60, 1}}, m.No)
+
+		require.Error(t, m.decode([]byte(`{"yes": [[40]]}`)))
+	})
+
+	t.Run("Corpus", func(t *testing.T) {
+		t.Parallel()
+		// The single-pass decoders agree with encoding/json.
+		var (
+			delta    bookDeltaMsg
+			snapshot bookSnapshotMsg
+		)
+		for _, message := range append(recordedCorpus(t), syntheticCorpus(t)...) {
+			header, msg, err := decodeMessage(message)
+			require.NoError(t, err)
+			var want subscriptionMessageHeader
+			require.NoError(t, json.Unmarshal(message, &want))
+			require.Equal(t, want, header)
+
+			switch header.Type {
+			case "orderbook_delta":
+				var want orderBookDelta
+				require.NoError(t, json.Unmarshal(message, &want))
+				require.NoError(t, delta.decode(msg))
+				require.Equal(t, want.Msg.MarketTicker, string(delta.MarketTicker))
+				require.Equal(t, want.Msg.Side, delta.Side)
+				require.Equal(t, want.Msg.Price, delta.Price)
+				require.Equal(t, want.Msg.Delta, delta.Delta)
+			case "orderbook_snapshot":
+				var want orderBookSnapshot
+				require.NoError(t, json.Unmarshal(message, &want))
+				require.NoError(t, snapshot.decode(msg))
+				require.Equal(t, want.Msg.MarketTicker, string(snapshot.MarketTicker))
+				require.Equal(t, want.Msg.Yes, snapshot.Yes)
+				require.Equal(t, want.Msg.No, snapshot.No)
+			}
+		}
+	})
+}
+
+func Test_decodeAllocs(t *testing.T) {
+	message := []byte(`{"type": "orderbook_delta", "sid": 2, "seq": 7, "msg": {"market_ticker": "A", "price": 40, "delta": -3, "side": "yes"}}`)
+	var delta bookDeltaMsg
+	allocs := testing.AllocsPerRun(100, func() {
+		_, msg, _ := decodeMessage(message)
+		_ = delta.decode(msg)
+	})
+	require.Zero(t, allocs)
+}
+
+// decodeMessageJSON decodes message like decodeMessage, but with
+// encoding/json. Keys are matched exactly, as by the scanner.
+func decodeMessageJSON(message []byte) (header subscriptionMessageHeader, msg []byte, err error) {
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(message, &fields)
+	if err != nil {
+		return header, nil, err
+	}
+	for key, raw := range fields {
+		switch key {
+		case "id":
+			err = json.Unmarshal(raw, &header.ID)
+		case "type":
+			err = json.Unmarshal(raw, &header.Type)
+		case "sid":
+			err = json.Unmarshal(raw, &header.Sid)
+		case "seq":
+			err = json.Unmarshal(raw, &header.Seq)
+		case "msg":
+			msg = raw
+		}
+		if err != nil {
+			return header, nil, err
+		}
+	}
+	return header, msg, nil
+}
+
+// decodeBookMsgJSON decodes msg like bookDeltaMsg and bookSnapshotMsg, but
+// with encoding/json. Bids that aren't pairs fail, as with the scanner.
+func decodeBookMsgJSON(msg []byte) (delta bookDeltaMsg, snapshot bookSnapshotMsg, err error) {
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(msg, &fields)
+	if err != nil {
+		return delta, snapshot, err
+	}
+	str := func(raw json.RawMessage) ([]byte, error) {
+		var s *string
+		err := json.Unmarshal(raw, &s)
+		if s == nil {
+			return nil, err
+		}
+		return []byte(*s), err
+	}
+	bids := func(raw json.RawMessage) (OrderBookBids, error) {
+		var pairs [][]int
+		err := json.Unmarshal(raw, &pairs)
+		if err != nil {
+			return nil, err
+		}
+		var bids OrderBookBids
+		for _, pair := range pairs {
+			if len(pair) != 2 {
+				return nil, fmt.Errorf("bid %v is not a pair", pair)
+			}
+			bids = append(bids, OrderBookBid{Price: Cents(pair[0]), Quantity: pair[1]})
+		}
+		return bids, nil
+	}
+	for key, raw := range fields {
+		switch key {
+		case "market_id":
+			delta.MarketID, err = str(raw)
+			snapshot.MarketID = delta.MarketID
+		case "market_ticker":
+			delta.MarketTicker, err = str(raw)
+			snapshot.MarketTicker = delta.MarketTicker
+		case "price":
+			err = json.Unmarshal(raw, &delta.Price)
+		case "delta":
+			err = json.Unmarshal(raw, &delta.Delta)
+		case "side":
+			var side []byte
+			side, err = str(raw)
+			delta.Side = sideOf(side)
+		case "yes":
+			snapshot.Yes, err = bids(raw)
+		case "no":
+			snapshot.No, err = bids(raw)
+		}
+		if err != nil {
+			return delta, snapshot, err
+		}
+	}
+	return delta, snapshot, nil
+}
+
+// FuzzDecodeMessage checks the scanner against encoding/json. Both must
+// accept the same messages and decode them alike.
+func FuzzDecodeMessage(f *testing.F) {
+	for _, message := range []string{
+		`{"type": "orderbook_delta", "sid": 2, "seq": 7, "msg": {"market_ticker": "A", "price": 40, "delta": -3, "side": "yes"}}`,
+		`{"extra": {"a": [1, "]}\"", {"b": null}], "c": true}, "msg": [1.5e3, "x"], "type": "custom", "seq": null}`,
+		`{"id": 1, "type": "orderbook_snapshot", "msg": {"market_ticker": "A\u00e9", "yes": [[40, 10]], "no": null}}`,
+		`{"sid": -0, "msg": {"yes": [[1, 2], [3]]}}`,
+		`{"x": [tru]}`,
+		`null`,
+	} {
+		f.Add([]byte(message))
+	}
+	for _, message := range syntheticCorpus(f)[:8] {
+		f.Add(message)
+	}
+	for _, message := range recordedCorpus(f) {
+		f.Add(message)
+	}
+
+	f.Fuzz(func(t *testing.T, message []byte) {
+		header, msg, err := decodeMessage(message)
+		wantHeader, wantMsg, wantErr := decodeMessageJSON(message)
+		if wantErr != nil {
+			require.Error(t, err)
+			return
+		}
+		require.NoError(t, err)
+		require.Equal(t, wantHeader, header)
+		require.Equal(t, string(wantMsg), string(msg))
+		if msg == nil {
+			return
+		}
+
+		wantDelta, wantSnapshot, wantErr := decodeBookMsgJSON(msg)
+		var (
+			delta    bookDeltaMsg
+			snapshot bookSnapshotMsg
+		)
+		deltaErr := delta.decode(msg)
+		snapshotErr := snapshot.decode(msg)
+		if wantErr != nil {
+			// The oracle decodes the fields of both messages, so only one
+			// of them needs to fail.
+			require.True(t, deltaErr != nil || snapshotErr != nil)
+			return
+		}
+		require.NoError(t, deltaErr)
+		require.NoError(t, snapshotErr)
+		require.Equal(t, string(wantDelta.MarketID), string(delta.MarketID))
+		require.Equal(t, string(wantDelta.MarketTicker), string(delta.MarketTicker))
+		require.Equal(t, wantDelta.Price, delta.Price)
+		require.Equal(t, wantDelta.Delta, delta.Delta)
+		require.Equal(t, wantDelta.Side, delta.Side)
+		requireBids := func(want, got OrderBookBids) {
+			// The scanner reuses empty bids instead of leaving them nil.
+			if len(want) == 0 {
+				require.Empty(t, got)
+				return
+			}
+			require.Equal(t, want, got)
+		}
+		requireBids(wantSnapshot.Yes, snapshot.Yes)
+		requireBids(wantSnapshot.No, snapshot.No)
+	})
+}
+
+// syntheticCorpus generates a stream of book and ticker messages shaped
+// like those of the exchange, writes it through a Recorder and returns the
+// messages read back from its journal. Unlike recordedCorpus, it needs no
+// capture of the exchange.
+func syntheticCorpus(t testing.TB) [][]byte {
+	dir := t.TempDir()
+	rec := &Recorder{Dir: dir}
+
+	record := func(seq int, typ string, msg any) {
+		message, err := json.Marshal(map[string]any{
+			"type": typ,
+			"sid":  1,
+			"seq":  seq,
+			"msg":  msg,
+		})
+		require.NoError(t, err)
+		require.NoError(t, rec.Record(JournalEntry{
+			ReceivedAt: time.Now(),
+			Type:       typ,
+			Sid:        1,
+			Seq:        seq,
+			Message:    message,
+		}))
+	}
+
+	tickers := []string{"KXBENCH-23DEC31-B1", "KXBENCH-23DEC31-B2", "KXBENCH-23DEC31-B3", "KXBENCH-23DEC31-B4"}
+	seq := 0
+	for _, ticker := range tickers {
+		var yes, no OrderBookBids
+		for p := Cents(1); p < 50; p++ {
+			yes = append(yes, OrderBookBid{p, 100 + int(p)})
+			no = append(no, OrderBookBid{p, 200 + int(p)})
+		}
+		seq++
+		record(seq, "orderbook_snapshot", map[string]any{
+			"market_ticker": ticker,
+			"yes":           yes,
+			"no":            no,
+		})
+	}
+	for i, d := range benchmarkDeltas() {
+		ticker := tickers[i%len(tickers)]
+		if i%16 == 0 {
+			record(0, "ticker", Ticker{
+				MarketTicker: ticker,
+				Price:        d.Msg.Price,
+				YesBid:       d.Msg.Price,
+				YesAsk:       d.Msg.Price + 1,
+				Volume:       1000 + i,
+				OpenInterest: 500,
+				Ts:           Timestamp(time.Unix(1700000000, 0)),
+			})
+			continue
+		}
+		seq++
+		record(seq, "orderbook_delta", map[string]any{
+			"market_ticker": ticker,
+			"price":         d.Msg.Price,
+			"delta":         d.Msg.Delta,
+			"side":          d.Msg.Side,
+		})
+	}
+	require.NoError(t, rec.Close())
+	return journalCorpus(t, dir)
+}
+
+// recordedCorpusDir holds journals of the exchange written by
+// TestRecordCorpus.
+const recordedCorpusDir = "testdata/corpus"
+
+// recordedCorpus returns the messages journaled in recordedCorpusDir, or nil
+// if nothing was recorded.
+func recordedCorpus(t testing.TB) [][]byte {
+	if _, err := os.Stat(recordedCorpusDir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return journalCorpus(t, recordedCorpusDir)
+}
+
+// journalCorpus returns the messages of every journal in dir.
+func journalCorpus(t testing.TB, dir string) [][]byte {
+	files, err := JournalFiles(dir, "")
+	require.NoError(t, err)
+
+	var corpus [][]byte
+	for _, name := range files {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		r, err := NewJournalReader(f)
+		require.NoError(t, err)
+		for {
+			e, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			corpus = append(corpus, e.Message)
+		}
+		require.NoError(t, f.Close())
+	}
+	return corpus
+}
+
+// TestRecordCorpus records the books and tickers of the busiest demo markets
+// into recordedCorpusDir for BenchmarkDecodeMessage. It needs the
+// credentials of testClient, and only runs when $RECORD_CORPUS is set.
+func TestRecordCorpus(t *testing.T) {
+	if os.Getenv("RECORD_CORPUS") == "" {
+		t.Skip("set $RECORD_CORPUS to record a corpus")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	client := testClient(t)
+	markets := highestVolumeMarkets(ctx, t, client)
+	if len(markets) > 5 {
+		markets = markets[:5]
+	}
+	var tickers []string
+	for _, m := range markets {
+		tickers = append(tickers, m.Ticker)
+	}
+
+	// Journals of earlier recordings are replaced.
+	require.NoError(t, os.RemoveAll(recordedCorpusDir))
+	f, err := client.OpenFeed(ctx)
+	require.NoError(t, err)
+	defer f.Close()
+	f.Recorder = &Recorder{Dir: recordedCorpusDir}
+
+	books := make(chan *StreamOrderBook, 64)
+	tickerUpdates := make(chan Ticker, 64)
+	go func() {
+		_ = f.Books(ctx, tickers, books)
+	}()
+	go func() {
+		_ = f.Tickers(ctx, tickers, tickerUpdates)
+	}()
+	for ctx.Err() == nil {
+		select {
+		case <-books:
+		case <-tickerUpdates:
+		case <-ctx.Done():
+		}
+	}
+	require.NoError(t, f.Recorder.Close())
+	require.NotEmpty(t, recordedCorpus(t))
+}
+
+// BenchmarkDecodeMessage decodes the journals recorded by TestRecordCorpus,
+// and the synthetic corpus.
+func BenchmarkDecodeMessage(b *testing.B) {
+	for _, c := range []struct {
+		name   string
+		corpus func(testing.TB) [][]byte
+	}{
+		{"Recorded", recordedCorpus},
+		{"Synthetic", syntheticCorpus},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			corpus := c.corpus(b)
+			if len(corpus) == 0 {
+				b.Skipf("no journal in %s; record one with RECORD_CORPUS=1 go test -run TestRecordCorpus", recordedCorpusDir)
+			}
+			benchmarkDecodeMessage(b, corpus)
+		})
+	}
+}
+
+func benchmarkDecodeMessage(b *testing.B, corpus [][]byte) {
+	var size int64
+	for _, message := range corpus {
+		size += int64(len(message))
+	}
+
+	// Unmarshal decodes messages as the Feed did before single-pass
+	// decoding: the header first, then the whole message.
+	b.Run("Unmarshal", func(b *testing.B) {
+		b.SetBytes(size / int64(len(corpus)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			message := corpus[i%len(corpus)]
+			var header subscriptionMessageHeader
+			if err := json.Unmarshal(message, &header); err != nil {
+				b.Fatal(err)
+			}
+			var err error
+			switch header.Type {
+			case "orderbook_snapshot":
+				var m orderBookSnapshot
+				err = json.Unmarshal(message, &m)
+			case "orderbook_delta":
+				var m orderBookDelta
+				err = json.Unmarshal(message, &m)
+			case "ticker":
+				var m struct {
+					Msg Ticker `json:"msg"`
+				}
+				err = json.Unmarshal(message, &m)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("SinglePass", func(b *testing.B) {
+		var (
+			snapshot bookSnapshotMsg
+			delta    bookDeltaMsg
+		)
+		b.SetBytes(size / int64(len(corpus)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			header, msg, err := decodeMessage(corpus[i%len(corpus)])
+			if err != nil {
+				b.Fatal(err)
+			}
+			// Other messages, such as command responses, only need their
+			// header.
+			switch header.Type {
+			case "orderbook_snapshot":
+				err = snapshot.decode(msg)
+			case "orderbook_delta":
+				err = delta.decode(msg)
+			case "ticker":
+				var m Ticker
+				err = json.Unmarshal(msg, &m)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
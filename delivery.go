@@ -0,0 +1,284 @@
+package kalshi
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// DeliveryPolicy decides what a Feed does when the consumer of a stream
+// falls behind. Every subscription of a Feed is read by a single
+// goroutine, so a consumer that blocks stalls all of them until the
+// exchange gives up on the connection.
+type DeliveryPolicy int
+
+const (
+	// BlockDelivery waits for the consumer. Nothing is lost, but a slow
+	// consumer stalls the Feed.
+	BlockDelivery DeliveryPolicy = iota
+	// DropOldest queues up to DeliveryQueueSize values per stream
+	// and discards the oldest when the queue is full.
+	DropOldest
+	// Conflate queues at most one book per market, replacing it with the
+	// latest until the consumer takes it. For BookEvents, the latest event
+	// of each type is kept per market, so consumers should read the book
+	// rather than replay level changes. Tickers keep the latest ticker per
+	// market and Orders the latest update per order. Trades, Fills and
+	// Lifecycle events never replace each other, so they are queued as
+	// with DropOldest.
+	Conflate
+)
+
+func (p DeliveryPolicy) String() string {
+	switch p {
+	case BlockDelivery:
+		return "block"
+	case DropOldest:
+		return "drop_oldest"
+	case Conflate:
+		return "conflate"
+	default:
+		return "unknown"
+	}
+}
+
+// DefaultDeliveryQueueSize is the queue size of DropOldest if
+// DeliveryQueueSize is not set.
+const DefaultDeliveryQueueSize = 1024
+
+// DeliveryStats counts values delivered to the consumers of a
+// Feed.
+type DeliveryStats struct {
+	// Queued is the number of values waiting for consumers.
+	Queued int64
+	// MaxQueued is the highest Queued has been.
+	MaxQueued int64
+	Delivered uint64
+	// Dropped counts values discarded by DropOldest.
+	Dropped uint64
+	// Conflated counts values replaced by Conflate.
+	Conflated uint64
+}
+
+// deliveryCounters is the live form of DeliveryStats.
+type deliveryCounters struct {
+	queued    atomic.Int64
+	maxQueued atomic.Int64
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+	conflated atomic.Uint64
+}
+
+func (c *deliveryCounters) enqueue() {
+	n := c.queued.Add(1)
+	for {
+		max := c.maxQueued.Load()
+		if n <= max || c.maxQueued.CompareAndSwap(max, n) {
+			return
+		}
+	}
+}
+
+// DeliveryStats returns the delivery counters of every stream of the Feed.
+func (s *Feed) DeliveryStats() DeliveryStats {
+	return DeliveryStats{
+		Queued:    s.delivery.queued.Load(),
+		MaxQueued: s.delivery.maxQueued.Load(),
+		Delivered: s.delivery.delivered.Load(),
+		Dropped:   s.delivery.dropped.Load(),
+		Conflated: s.delivery.conflated.Load(),
+	}
+}
+
+// deliverer decouples the reader goroutine from a consumer according to a
+// DeliveryPolicy.
+type deliverer[T any] struct {
+	policy   DeliveryPolicy
+	size     int
+	counters *deliveryCounters
+	// key identifies the values that replace each other under Conflate.
+	key func(T) string
+	out chan<- T
+
+	mu sync.Mutex
+	// queue holds values from head on. Under Conflate, it holds keys.
+	queue     []T
+	keys      []string
+	head      int
+	conflated map[string]T
+	ready     chan struct{}
+	// closed is set once the consumer is gone.
+	closed bool
+}
+
+// deliver returns a function that delivers values to out according to the
+// delivery policy of the Feed. Queued values are discarded once ctx is
+// done. If key is nil, Conflate falls back to DropOldest.
+func deliver[T any](ctx context.Context, s *Feed, out chan<- T, key func(T) string) func(T) {
+	if s.Delivery == BlockDelivery {
+		return func(v T) {
+			select {
+			case out <- v:
+				s.delivery.delivered.Add(1)
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	d := newDeliverer(s, out, key)
+	go d.run(ctx)
+	return d.push
+}
+
+func newDeliverer[T any](s *Feed, out chan<- T, key func(T) string) *deliverer[T] {
+	d := &deliverer[T]{
+		policy:    s.Delivery,
+		size:      s.DeliveryQueueSize,
+		counters:  &s.delivery,
+		key:       key,
+		out:       out,
+		conflated: make(map[string]T),
+		ready:     make(chan struct{}, 1),
+	}
+	if d.size <= 0 {
+		d.size = DefaultDeliveryQueueSize
+	}
+	if key == nil && d.policy == Conflate {
+		d.policy = DropOldest
+	}
+	return d
+}
+
+// len returns the number of queued values. d.mu must be held.
+func (d *deliverer[T]) len() int {
+	if d.policy == Conflate {
+		return len(d.keys) - d.head
+	}
+	return len(d.queue) - d.head
+}
+
+// push queues v without blocking.
+func (d *deliverer[T]) push(v T) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	switch d.policy {
+	case Conflate:
+		k := d.key(v)
+		if _, ok := d.conflated[k]; ok {
+			d.conflated[k] = v
+			d.mu.Unlock()
+			d.counters.conflated.Add(1)
+			return
+		}
+		d.conflated[k] = v
+		d.keys = append(d.keys, k)
+	default:
+		if d.len() >= d.size {
+			d.pop()
+			d.counters.dropped.Add(1)
+		}
+		d.queue = append(d.queue, v)
+	}
+	d.counters.enqueue()
+	d.mu.Unlock()
+
+	select {
+	case d.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop dequeues the oldest value. d.mu must be held and the queue must not be
+// empty.
+func (d *deliverer[T]) pop() T {
+	var (
+		v    T
+		zero T
+	)
+	if d.policy == Conflate {
+		k := d.keys[d.head]
+		d.keys[d.head] = ""
+		v = d.conflated[k]
+		delete(d.conflated, k)
+	} else {
+		v = d.queue[d.head]
+		d.queue[d.head] = zero
+	}
+	d.head++
+	if d.len() == 0 {
+		d.queue = d.queue[:0]
+		d.keys = d.keys[:0]
+		d.head = 0
+	} else {
+		d.compact()
+	}
+	d.counters.queued.Add(-1)
+	return v
+}
+
+// compact moves the queued values to the front of the queue once more than
+// half of it has been consumed, so that a consumer that never catches up
+// does not grow the queue forever. d.mu must be held.
+func (d *deliverer[T]) compact() {
+	if d.policy == Conflate {
+		if 2*d.head < len(d.keys) {
+			return
+		}
+		n := copy(d.keys, d.keys[d.head:])
+		for i := n; i < len(d.keys); i++ {
+			d.keys[i] = ""
+		}
+		d.keys = d.keys[:n]
+	} else {
+		if 2*d.head < len(d.queue) {
+			return
+		}
+		var zero T
+		n := copy(d.queue, d.queue[d.head:])
+		for i := n; i < len(d.queue); i++ {
+			d.queue[i] = zero
+		}
+		d.queue = d.queue[:n]
+	}
+	d.head = 0
+}
+
+// run sends queued values to the consumer until ctx is done.
+func (d *deliverer[T]) run(ctx context.Context) {
+	defer func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.counters.queued.Add(-int64(d.len()))
+		d.queue, d.keys, d.head = nil, nil, 0
+		d.conflated = nil
+		d.closed = true
+	}()
+
+	for {
+		select {
+		case <-d.ready:
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			d.mu.Lock()
+			if d.len() == 0 {
+				d.mu.Unlock()
+				break
+			}
+			v := d.pop()
+			d.mu.Unlock()
+
+			select {
+			case d.out <- v:
+				d.counters.delivered.Add(1)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
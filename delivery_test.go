@@ -0,0 +1,225 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestDeliverer(t *testing.T) {
+	t.Parallel()
+
+	type value struct {
+		market string
+		n      int
+	}
+	key := func(v value) string {
+		return v.market
+	}
+
+	// drain runs d and receives n values.
+	drain := func(t *testing.T, d *deliverer[value], out chan value, n int) []value {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go d.run(ctx)
+
+		var got []value
+		for len(got) < n {
+			got = append(got, <-out)
+		}
+		return got
+	}
+
+	t.Run("DropOldest", func(t *testing.T) {
+		t.Parallel()
+		s := &Feed{Delivery: DropOldest, DeliveryQueueSize: 2}
+		out := make(chan value)
+		d := newDeliverer(s, out, key)
+		for n := 1; n <= 5; n++ {
+			d.push(value{"A", n})
+		}
+		require.Equal(t, DeliveryStats{Queued: 2, MaxQueued: 2, Dropped: 3}, s.DeliveryStats())
+
+		require.Equal(t, []value{{"A", 4}, {"A", 5}}, drain(t, d, out, 2))
+		require.Eventually(t, func() bool {
+			return s.DeliveryStats() == DeliveryStats{MaxQueued: 2, Delivered: 2, Dropped: 3}
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("Conflate", func(t *testing.T) {
+		t.Parallel()
+		s := &Feed{Delivery: Conflate}
+		out := make(chan value)
+		d := newDeliverer(s, out, key)
+		for n := 1; n <= 3; n++ {
+			d.push(value{"A", n})
+			d.push(value{"B", n})
+		}
+		d.push(value{"A", 4})
+		require.Equal(t, DeliveryStats{Queued: 2, MaxQueued: 2, Conflated: 5}, s.DeliveryStats())
+
+		// Markets keep the order in which they were first queued.
+		require.Equal(t, []value{{"A", 4}, {"B", 3}}, drain(t, d, out, 2))
+		require.Equal(t, int64(0), s.DeliveryStats().Queued)
+	})
+
+	t.Run("Bounded", func(t *testing.T) {
+		t.Parallel()
+		const size = 4
+
+		// The queue never drains, since DropOldest keeps it full.
+		s := &Feed{Delivery: DropOldest, DeliveryQueueSize: size}
+		d := newDeliverer(s, make(chan value), key)
+		for n := 0; n < 100000; n++ {
+			d.push(value{"A", n})
+		}
+		require.Equal(t, size, d.len())
+		require.LessOrEqual(t, cap(d.queue), 4*size)
+
+		// The consumer stays one market behind.
+		s = &Feed{Delivery: Conflate}
+		d = newDeliverer(s, make(chan value), key)
+		d.push(value{"M0", 0})
+		for n := 1; n < 100000; n++ {
+			d.push(value{fmt.Sprintf("M%d", n), n})
+			d.mu.Lock()
+			require.Equal(t, value{fmt.Sprintf("M%d", n-1), n - 1}, d.pop())
+			d.mu.Unlock()
+		}
+		require.Equal(t, 1, d.len())
+		require.LessOrEqual(t, cap(d.keys), 8)
+	})
+}
+
+func TestFeedDelivery(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const deltas = 100
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := readCommand(ctx, t, c)
+		msgs := []string{
+			fmt.Sprintf(testSubscribed, cmd.ID, 1),
+			fmt.Sprintf(testSnapshot, 1, 40),
+		}
+		for seq := 2; seq <= deltas+1; seq++ {
+			msgs = append(msgs, fmt.Sprintf(testDelta, 1, seq, 50))
+		}
+		writeMessages(ctx, t, c, msgs...)
+		<-ctx.Done()
+	})
+	f.Delivery = Conflate
+
+	// Nobody reads books while the messages arrive.
+	books := make(chan *StreamOrderBook)
+	go func() {
+		_ = f.Book(ctx, "DUH", books)
+	}()
+	// The snapshot is held by the forwarder, and the latest book replaces
+	// every delta after the first.
+	require.Eventually(t, func() bool {
+		return f.DeliveryStats().Conflated == deltas-1
+	}, time.Second*5, time.Millisecond*5)
+
+	<-books
+	book := <-books
+	require.Equal(t, OrderBookBids{{40, 10}, {50, deltas * 5}}, book.YesBids)
+	require.Eventually(t, func() bool {
+		return f.DeliveryStats() == DeliveryStats{
+			MaxQueued: 1,
+			Delivered: 2,
+			Conflated: deltas - 1,
+		}
+	}, time.Second, time.Millisecond)
+}
+
+func TestFeedDeliveryMessages(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Tickers", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		const updates = 10
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			acceptSubscription(ctx, t, c, 1)
+			var msgs []string
+			for n := 1; n <= updates; n++ {
+				for _, market := range []string{"A", "B"} {
+					msgs = append(msgs, fmt.Sprintf(
+						`{"type": "ticker", "sid": 1, "msg": {"market_ticker": %q, "price": %d}}`,
+						market, n,
+					))
+				}
+			}
+			writeMessages(ctx, t, c, msgs...)
+			<-ctx.Done()
+		})
+		f.Delivery = Conflate
+
+		tickers := make(chan Ticker)
+		go func() {
+			_ = f.Tickers(ctx, nil, tickers)
+		}()
+		// At most one ticker is held by the forwarder and one is queued
+		// per market.
+		require.Eventually(t, func() bool {
+			return f.DeliveryStats().Conflated >= 2*updates-3
+		}, time.Second*5, time.Millisecond*5)
+
+		latest := make(map[string]Cents)
+		received := 0
+		for latest["A"] != updates || latest["B"] != updates {
+			tick := <-tickers
+			latest[tick.MarketTicker] = tick.Price
+			received++
+		}
+		require.Equal(t, uint64(2*updates-received), f.DeliveryStats().Conflated)
+	})
+
+	t.Run("Trades", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		const trades = 10
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			acceptSubscription(ctx, t, c, 1)
+			var msgs []string
+			for seq := 1; seq <= trades; seq++ {
+				msgs = append(msgs, fmt.Sprintf(
+					`{"type": "trade", "sid": 1, "seq": %d, "msg": {"trade_id": "t%d", "market_ticker": "DUH", "count": 1}}`,
+					seq, seq,
+				))
+			}
+			writeMessages(ctx, t, c, msgs...)
+			<-ctx.Done()
+		})
+		// Trades never replace each other, so Conflate queues them.
+		f.Delivery = Conflate
+		f.DeliveryQueueSize = 2
+
+		feed := make(chan Trade)
+		go func() {
+			_ = f.Trades(ctx, nil, feed)
+		}()
+		require.Eventually(t, func() bool {
+			stats := f.DeliveryStats()
+			return stats.Dropped == trades-3 && stats.Queued == 2
+		}, time.Second*5, time.Millisecond*5)
+		require.Zero(t, f.DeliveryStats().Conflated)
+
+		var ids []string
+		for len(ids) < 3 {
+			ids = append(ids, (<-feed).TradeID)
+		}
+		require.Equal(t, []string{"t9", "t10"}, ids[1:])
+	})
+}
@@ -1,12 +1,15 @@
 package kalshi
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
-	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"nhooyr.io/websocket"
@@ -16,15 +19,151 @@ import (
 // Feed is a websocket connection to the Kalshi streaming API.
 // Feed is described in more detail here:
 // https://trading-api.readme.io/reference/introduction.
+//
+// A single Feed may follow many markets at once. Every subscription is
+// delivered to its own consumer by a reader goroutine that owns the
+// connection, and the methods of Feed may be called concurrently. The
+// exported fields configure the Feed and must be set before the first
+// subscription.
+//
 // WARNING: Feed has not been thoroughly tested.
 type Feed struct {
-	c *websocket.Conn
+	// IntegrityAction is taken when a streamed book fails an integrity
+	// check. See IntegrityError.
+	IntegrityAction IntegrityAction
+	// OnIntegrityError, if set, is called from the read loop every time a
+	// streamed book fails an integrity check, before IntegrityAction is
+	// taken.
+	OnIntegrityError func(marketID string, err *IntegrityError)
+
+	// Reconnect, if set, makes the Feed recover from dropped connections
+	// and sequence gaps instead of failing. See ReconnectPolicy.
+	Reconnect *ReconnectPolicy
+	// OnStateChange, if set, is called from the reader goroutine on every
+	// transition of the connection state. err is the cause of the
+	// transition, if any.
+	OnStateChange func(state FeedState, err error)
+	// Keepalive, if set, pings the exchange to detect dead connections. See
+	// KeepalivePolicy.
+	Keepalive *KeepalivePolicy
+	// Delivery decides what happens when the consumer of a stream falls
+	// behind. It applies to every channel method, but not to handlers. See
+	// DeliveryPolicy.
+	Delivery DeliveryPolicy
+	// DeliveryQueueSize bounds the queue of DropOldest. It defaults to
+	// DefaultDeliveryQueueSize.
+	DeliveryQueueSize int
+	// Recorder, if set, journals every message read by the Feed, including
+	// messages that fail to decode.
+	Recorder *Recorder
+	// Verify, if set, periodically checks streamed books against the REST
+	// API. It requires the Feed to have been opened by a Client. See
+	// VerifyPolicy.
+	Verify *VerifyPolicy
+	// WarmStart, if set, preloads streamed books from a saved BookState.
+	// See WarmStartPolicy.
+	WarmStart *WarmStartPolicy
+
+	dial   func(ctx context.Context) (*websocket.Conn, error)
+	client *Client
+	// send, if set, replaces the connection for commands.
+	send   func(ctx context.Context, c command) error
+	cancel context.CancelFunc
+	// done is closed when the reader goroutine exits.
+	done chan struct{}
+	// resubscribe is signaled after every reconnect.
+	resubscribe   chan struct{}
+	keepaliveOnce sync.Once
+	delivery      deliveryCounters
+
+	mu     sync.Mutex
+	c      *websocket.Conn
+	state  FeedState
+	closed bool
+	// abort stops reading the current connection.
+	abort context.CancelCauseFunc
+	// lastMessage is when the last message of any kind was read.
+	lastMessage time.Time
+	// messages and bytes count every message read.
+	messages       map[string]uint64
+	bytes          uint64
+	reconnectCount atomic.Uint64
+	gaps           atomic.Uint64
+	// stateListeners are called after OnStateChange.
+	stateListeners []stateListener
+	nextListener   int
+	// nextID is the ID of the next command.
+	nextID int
+	// pending holds commands awaiting a response by ID.
+	pending map[int]*pendingCommand
+	// subs holds the subscriptions of the current connection by sid.
+	subs map[int]*subscription
+	// active holds every subscription that has been acknowledged and not
+	// yet ended, including those waiting to be resubscribed.
+	active map[*subscription]struct{}
+	// bookStreams holds the running book streams for BookState.
+	bookStreams map[*bookStream]struct{}
+	// err is the error that stopped the reader.
+	err error
 }
 
+// IntegrityAction is the reaction of a Feed to a streamed book that fails an
+// integrity check.
+type IntegrityAction int
+
+const (
+	// IgnoreIntegrity takes no action. Books are only checked if
+	// OnIntegrityError is set, and books that fail are still sent.
+	IgnoreIntegrity IntegrityAction = iota
+	// FailOnIntegrity stops the stream with the *IntegrityError. The
+	// message that failed the check is never applied to the book.
+	FailOnIntegrity
+	// ResyncOnIntegrity discards the message that failed the check and
+	// resubscribes to receive a fresh snapshot. A snapshot that keeps
+	// failing, such as a book the exchange itself has crossed, is loaded as
+	// sent after three resyncs, or fails the stream if it has invalid
+	// levels.
+	ResyncOnIntegrity
+)
+
 type commandParams struct {
-	Channels     []string `json:"channels,omitempty"`
-	MarketTicker string   `json:"market_ticker,omitempty"`
+	Channels      []string `json:"channels,omitempty"`
+	MarketTicker  string   `json:"market_ticker,omitempty"`
+	MarketTickers []string `json:"market_tickers,omitempty"`
+	Sids          []int    `json:"sids,omitempty"`
+	Action        string   `json:"action,omitempty"`
+}
+
+// marketTickers returns every market ticker of p.
+func (p commandParams) marketTickers() []string {
+	if p.MarketTicker != "" {
+		return []string{p.MarketTicker}
+	}
+	return p.MarketTickers
+}
+
+// update returns p after applying an update_subscription command.
+func (p commandParams) update(u commandParams) commandParams {
+	changed := make(map[string]bool, len(u.MarketTickers))
+	for _, ticker := range u.MarketTickers {
+		changed[ticker] = true
+	}
+
+	var tickers []string
+	for _, ticker := range p.marketTickers() {
+		if !changed[ticker] {
+			tickers = append(tickers, ticker)
+		}
+	}
+	if u.Action == string(AddMarkets) {
+		tickers = append(tickers, u.MarketTickers...)
+	}
+
+	p.MarketTicker = ""
+	p.MarketTickers = tickers
+	return p
 }
+
 type command struct {
 	ID      int           `json:"id,omitempty"`
 	Command string        `json:"cmd,omitempty"`
@@ -32,35 +171,67 @@ type command struct {
 }
 
 func (s *Feed) sendCommand(ctx context.Context, c command) error {
-	return wsjson.Write(ctx, s.c, c)
+	if s.send != nil {
+		return s.send(ctx, c)
+	}
+	return wsjson.Write(ctx, s.conn(), c)
 }
 
-type subscribedResponse struct {
-	ID   int    `json:"id"`
-	Type string `json:"type"`
-	Msg  struct {
-		Channel string `json:"channel"`
-		Sid     int    `json:"sid"`
-		Msg     string `json:"msg"`
-	} `json:"msg"`
+// conn returns the current connection.
+func (s *Feed) conn() *websocket.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.c
+}
+
+// commandResponse acknowledges a command. It is matched to the command by
+// ID.
+type commandResponse struct {
+	ID   int             `json:"id"`
+	Type string          `json:"type"`
+	Sid  int             `json:"sid"`
+	Seq  int             `json:"seq"`
+	Msg  json.RawMessage `json:"msg"`
+}
+
+func parseCommandResponse(message []byte) (commandResponse, error) {
+	var r commandResponse
+	err := json.Unmarshal(message, &r)
+	if err != nil {
+		return r, fmt.Errorf("unmarshal response: %w", err)
+	}
+	if r.Type == "subscribed" {
+		// The sid of a new subscription is nested in the message.
+		var msg struct {
+			Sid int `json:"sid"`
+		}
+		err = json.Unmarshal(r.Msg, &msg)
+		if err != nil {
+			return r, fmt.Errorf("unmarshal subscribed: %w", err)
+		}
+		r.Sid = msg.Sid
+	}
+	return r, nil
 }
 
 type orderBookSnapshot struct {
 	subscriptionMessageHeader
 	Msg struct {
-		MarketID string        `json:"market_id"`
-		Yes      OrderBookBids `json:"yes"`
-		No       OrderBookBids `json:"no"`
+		MarketID     string        `json:"market_id"`
+		MarketTicker string        `json:"market_ticker"`
+		Yes          OrderBookBids `json:"yes"`
+		No           OrderBookBids `json:"no"`
 	} `json:"msg"`
 }
 
 type orderBookDelta struct {
 	subscriptionMessageHeader
 	Msg struct {
-		MarketID string `json:"market_id"`
-		Price    Cents  `json:"price"`
-		Delta    int    `json:"delta"`
-		Side     Side   `json:"side"`
+		MarketID     string `json:"market_id"`
+		MarketTicker string `json:"market_ticker"`
+		Price        Cents  `json:"price"`
+		Delta        int    `json:"delta"`
+		Side         Side   `json:"side"`
 	}
 }
 
@@ -72,92 +243,692 @@ type errorMessage struct {
 	} `json:"msg,omitempty"`
 }
 
+// FeedError is an error message sent by the exchange.
+type FeedError struct {
+	Code    int
+	Message string
+}
+
+func (e *FeedError) Error() string {
+	return fmt.Sprintf("error message (%v): %v", e.Code, e.Message)
+}
+
 type subscriptionMessageHeader struct {
+	ID   int    `json:"id"`
 	Type string `json:"type"`
 	Sid  int    `json:"sid"`
 	Seq  int    `json:"seq"`
 }
 
+// subscriptionHandler processes the messages of a subscription. Its
+// functions are only called by the reader goroutine.
+type subscriptionHandler struct {
+	// sequenced subscriptions require every message to carry the next
+	// sequence number.
+	sequenced bool
+	// handle is called with the payload of every message of the
+	// subscription. The payload is only valid during the call. An error ends
+	// the subscription.
+	handle func(header subscriptionMessageHeader, msg []byte) error
+	// reset, if set, discards all state before the subscription starts or
+	// restarts after a reconnect.
+	reset func()
+	// started, if set, is called after reset once the exchange acknowledges
+	// the subscription, before any of its messages. Unlike reset, it is
+	// called without the lock of the Feed, so it may emit to consumers.
+	started func()
+	// update, if set, is called when an update of the market tickers of the
+	// subscription is acknowledged, before any messages of added markets.
+	update func(action UpdateAction, marketTickers []string)
+	// stats is shared by the subscriptions that serve a stream.
+	stats *streamStats
+	// ref, if set, follows the subscription that currently serves the
+	// stream.
+	ref *streamRef
+}
+
+// streamRef points to the subscription that serves a stream, which changes
+// whenever the stream resyncs. sub is guarded by the mutex of the Feed.
+type streamRef struct {
+	sub *subscription
+}
+
+// subscription receives the messages of a single sid from the reader.
+type subscription struct {
+	subscriptionHandler
+	params commandParams
+
+	// sid is zero until the subscription is acknowledged on the current
+	// connection.
+	sid     int
+	wantSeq int
+	// lastMessage is when the last message of the subscription was read,
+	// or when it was acknowledged.
+	lastMessage time.Time
+	// done receives the error that ended the subscription.
+	done chan error
+	// dropped is set once the owner of the subscription abandons it.
+	dropped bool
+}
+
+func newSubscription(params commandParams, h subscriptionHandler) *subscription {
+	return &subscription{
+		subscriptionHandler: h,
+		params:              params,
+		done:                make(chan error, 1),
+	}
+}
+
+// wait blocks until the subscription ends or ctx is done.
+func (sub *subscription) wait(ctx context.Context) error {
+	select {
+	case err := <-sub.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type pendingCommand struct {
+	cmd command
+	// sub is registered under the sid of a subscribed response before any
+	// of its messages are read, and updated by an ok response.
+	sub  *subscription
+	resp chan commandResponse
+	err  chan error
+}
+
+// command sends cmd with a new ID and waits for the response.
+func (s *Feed) command(ctx context.Context, cmd command, sub *subscription) (commandResponse, error) {
+	p := &pendingCommand{
+		sub:  sub,
+		resp: make(chan commandResponse, 1),
+		err:  make(chan error, 1),
+	}
+
+	s.keepaliveOnce.Do(s.startKeepalive)
+
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return commandResponse{}, s.err
+	}
+	s.nextID++
+	cmd.ID = s.nextID
+	p.cmd = cmd
+	s.pending[cmd.ID] = p
+	s.mu.Unlock()
+
+	err := s.sendCommand(ctx, cmd)
+	if err != nil {
+		s.mu.Lock()
+		delete(s.pending, cmd.ID)
+		s.mu.Unlock()
+		return commandResponse{}, err
+	}
+
+	select {
+	case r := <-p.resp:
+		return r, nil
+	case err := <-p.err:
+		return commandResponse{}, err
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		// The response may have raced with cancellation, in which case the
+		// caller must still clean up after it.
+		select {
+		case r := <-p.resp:
+			return r, nil
+		default:
+		}
+		// The command stays pending so that its response is still handled:
+		// a subscription acknowledged after its owner gave up is dropped
+		// on arrival.
+		if p.sub != nil && cmd.Command == "subscribe" {
+			p.sub.dropped = true
+		}
+		return commandResponse{}, ctx.Err()
+	}
+}
+
+// subscribe subscribes to the channel of sub and registers sub to receive its
+// messages.
+func (s *Feed) subscribe(ctx context.Context, sub *subscription) error {
+	s.mu.Lock()
+	params := sub.params
+	s.mu.Unlock()
+
+	r, err := s.command(ctx, command{
+		Command: "subscribe",
+		Params:  params,
+	}, sub)
+	if err != nil {
+		return err
+	}
+	if r.Type != "subscribed" {
+		return fmt.Errorf("unexpected message: %+v", r)
+	}
+	return nil
+}
+
+// subscribeConnected is like subscribe, but a Feed that reconnects waits
+// for a connection instead of failing, and retries on the next connection
+// if the current one is lost before the exchange acknowledges sub.
+func (s *Feed) subscribeConnected(ctx context.Context, sub *subscription) error {
+	if s.Reconnect == nil {
+		return s.subscribe(ctx, sub)
+	}
+	var lost *websocket.Conn
+	for {
+		err := s.waitConnected(ctx, lost)
+		if err != nil {
+			return err
+		}
+		c := s.conn()
+		err = s.subscribe(ctx, sub)
+		var ferr *FeedError
+		if err == nil || ctx.Err() != nil || errors.As(err, &ferr) || s.Err() != nil {
+			return err
+		}
+		lost = c
+	}
+}
+
+// waitConnected waits until the Feed is connected on a connection other
+// than lost, which may be nil.
+func (s *Feed) waitConnected(ctx context.Context, lost *websocket.Conn) error {
+	changed := make(chan struct{}, 1)
+	stop := s.listenState(func(FeedState, error) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+
+	for {
+		s.mu.Lock()
+		state, c, err := s.state, s.c, s.err
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		if state == FeedConnected && c != lost {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			if err := s.Err(); err != nil {
+				return err
+			}
+			return errors.New("feed closed")
+		}
+	}
+}
+
+// dropSubscription stops delivering messages to sub and asks the exchange to
+// stop sending them. It does not wait for the exchange to respond.
+func (s *Feed) dropSubscription(sub *subscription) {
+	s.mu.Lock()
+	if s.subs[sub.sid] == sub {
+		delete(s.subs, sub.sid)
+	}
+	delete(s.active, sub)
+	// Subscriptions without a sid are not acknowledged on the current
+	// connection.
+	if sub.dropped || sub.sid == 0 || s.err != nil {
+		sub.dropped = true
+		s.mu.Unlock()
+		return
+	}
+	sub.dropped = true
+	sid := sub.sid
+	s.mu.Unlock()
+
+	s.unsubscribe(sid)
+}
+
+// unsubscribe asks the exchange to stop sending the messages of sid.
+func (s *Feed) unsubscribe(sid int) {
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_ = s.sendCommand(ctx, command{
+		ID:      id,
+		Command: "unsubscribe",
+		Params:  commandParams{Sids: []int{sid}},
+	})
+}
+
+// read is the reader goroutine. It owns the read side of the connection.
+func (s *Feed) read(ctx context.Context) {
+	defer close(s.done)
+
+	c := s.conn()
+	for {
+		err := s.readConn(ctx, c)
+		if !s.reconnects(ctx) {
+			s.fail(err)
+			return
+		}
+		c, err = s.reconnect(ctx, err)
+		if err != nil {
+			s.fail(err)
+			return
+		}
+	}
+}
+
+// readConn reads and dispatches messages from c until it fails or is
+// aborted.
+func (s *Feed) readConn(ctx context.Context, c *websocket.Conn) error {
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	s.mu.Lock()
+	s.abort = abort
+	s.mu.Unlock()
+
+	// buf is reused by every message, so messages must not be retained
+	// past their dispatch.
+	var buf bytes.Buffer
+	for {
+		message, err := readMessage(ctx, c, &buf)
+		if err != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, ErrStale) {
+				return cause
+			}
+			return fmt.Errorf("read message: %w", err)
+		}
+
+		header, msg, err := decodeMessage(message)
+		if s.Recorder != nil {
+			// Messages that fail to decode are journaled too, with an
+			// empty header, since they are the ones worth debugging.
+			e := JournalEntry{
+				ReceivedAt: time.Now(),
+				Message:    message,
+			}
+			if err == nil {
+				e.Type, e.Sid, e.Seq = header.Type, header.Sid, header.Seq
+			}
+			// Errors are kept by the Recorder.
+			_ = s.Recorder.Record(e)
+		}
+		if err != nil {
+			return fmt.Errorf("read header: %w", err)
+		}
+
+		err = s.dispatch(header, message, msg)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readMessage reads the next message from c into buf.
+func readMessage(ctx context.Context, c *websocket.Conn, buf *bytes.Buffer) ([]byte, error) {
+	_, r, err := c.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	buf.Reset()
+	_, err = buf.ReadFrom(r)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dispatch routes a message to the command or subscription it belongs to.
+// msg is the payload of the message. Messages of unknown subscriptions are
+// dropped since they may still be in flight after an unsubscribe.
+func (s *Feed) dispatch(header subscriptionMessageHeader, message, msg []byte) error {
+	sub := s.received(header, len(message))
+
+	switch header.Type {
+	case "subscribed", "unsubscribed", "ok":
+		r, err := parseCommandResponse(message)
+		if err != nil {
+			return err
+		}
+		acked := s.respond(r.ID, r, nil)
+		if acked != nil && acked.started != nil {
+			acked.started()
+		}
+		return nil
+	case "error":
+		var errMsg errorMessage
+		err := json.Unmarshal(message, &errMsg)
+		if err != nil {
+			return fmt.Errorf("unmarshal error: %w", err)
+		}
+		ferr := &FeedError{Code: errMsg.Msg.Code, Message: errMsg.Msg.Msg}
+		if header.ID != 0 {
+			s.respond(header.ID, commandResponse{}, ferr)
+			return nil
+		}
+		if sub != nil {
+			s.endSubscription(sub, ferr)
+		}
+		return nil
+	}
+
+	if sub == nil {
+		return nil
+	}
+
+	if sub.sequenced || header.Seq != 0 {
+		if header.Seq != sub.wantSeq {
+			s.sequenceGap(sub, header)
+			return nil
+		}
+		sub.wantSeq++
+	}
+
+	err := sub.handle(header, msg)
+	if err != nil {
+		s.endSubscription(sub, err)
+	}
+	return nil
+}
+
+// sequenceGap handles a message that skipped ahead of the sequence of sub.
+func (s *Feed) sequenceGap(sub *subscription, header subscriptionMessageHeader) {
+	err := fmt.Errorf("unexpected sequence %v, want %v", header.Seq, sub.wantSeq)
+	sub.stats.gaps.Add(1)
+	s.gaps.Add(1)
+	if s.Reconnect == nil {
+		s.endSubscription(sub, err)
+		return
+	}
+	s.endSubscription(sub, errResync)
+}
+
+func (s *Feed) subscription(sid int) *subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.subs[sid]
+}
+
+// received records the arrival of a message of size bytes and returns its
+// subscription, if any.
+func (s *Feed) received(header subscriptionMessageHeader, size int) *subscription {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastMessage = now
+	s.messages[header.Type]++
+	s.bytes += uint64(size)
+	sub := s.subs[header.Sid]
+	if sub != nil {
+		sub.lastMessage = now
+		sub.stats.messages[header.Type]++
+		sub.stats.bytes += uint64(size)
+	}
+	return sub
+}
+
+// respond completes the pending command id. It returns the subscription
+// the response started, if any.
+func (s *Feed) respond(id int, r commandResponse, err error) *subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.pending[id]
+	if !ok {
+		return nil
+	}
+	delete(s.pending, id)
+
+	if err != nil {
+		p.err <- err
+		return nil
+	}
+	var started *subscription
+	sub := p.sub
+	switch {
+	case sub == nil:
+	case r.Type == "subscribed":
+		if sub.dropped {
+			// The owner gave up while the subscription was in flight.
+			go s.unsubscribe(r.Sid)
+		} else {
+			sub.sid = r.Sid
+			sub.wantSeq = 1
+			sub.lastMessage = time.Now()
+			if sub.reset != nil {
+				sub.reset()
+			}
+			s.subs[r.Sid] = sub
+			s.active[sub] = struct{}{}
+			started = sub
+		}
+	case r.Type == "ok" && p.cmd.Command == "update_subscription":
+		sub.params = sub.params.update(p.cmd.Params)
+		if sub.update != nil {
+			sub.update(UpdateAction(p.cmd.Params.Action), p.cmd.Params.MarketTickers)
+		}
+		// The acknowledgement takes a place in the sequence of the
+		// subscription.
+		if r.Seq != 0 && r.Seq == sub.wantSeq {
+			sub.wantSeq++
+		}
+	}
+	p.resp <- r
+	return started
+}
+
+// endSubscription removes sub and reports err to its owner.
+func (s *Feed) endSubscription(sub *subscription, err error) {
+	s.mu.Lock()
+	if s.subs[sub.sid] == sub {
+		delete(s.subs, sub.sid)
+	}
+	delete(s.active, sub)
+	s.mu.Unlock()
+
+	select {
+	case sub.done <- err:
+	default:
+	}
+}
+
+// fail stops the Feed, reporting err to every command and subscription.
+func (s *Feed) fail(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	for id, p := range s.pending {
+		p.err <- err
+		delete(s.pending, id)
+	}
+	for sub := range s.active {
+		select {
+		case sub.done <- err:
+		default:
+		}
+	}
+	s.subs = make(map[int]*subscription)
+	s.active = make(map[*subscription]struct{})
+	s.mu.Unlock()
+
+	s.setState(FeedClosed, err)
+}
+
+// orderBookLevels is one side of a streamed order book. Kalshi prices are
+// whole cents between 1 and 99, so quantities are indexed directly by price
+// and deltas apply in constant time.
+type orderBookLevels struct {
+	quantity [100]int
+	// count is the number of non-empty levels.
+	count int
+	// best is the highest price with resting quantity, or zero when the side
+	// is empty.
+	best Cents
+}
+
+func validPrice(p Cents) bool {
+	return p >= 1 && p <= 99
+}
+
+func (l *orderBookLevels) set(price Cents, quantity int) {
+	prev := l.quantity[price]
+	l.quantity[price] = quantity
+	switch {
+	case prev == 0 && quantity != 0:
+		l.count++
+		if price > l.best {
+			l.best = price
+		}
+	case prev != 0 && quantity == 0:
+		l.count--
+		// Walk down to the next non-empty level. This is bounded by the
+		// width of the book.
+		for l.best > 0 && l.quantity[l.best] == 0 {
+			l.best--
+		}
+	}
+}
+
+// appendBids appends the non-empty levels to dst in ascending price order.
+func (l *orderBookLevels) appendBids(dst OrderBookBids) OrderBookBids {
+	for p := Cents(1); p <= l.best; p++ {
+		if q := l.quantity[p]; q != 0 {
+			dst = append(dst, OrderBookBid{Price: p, Quantity: q})
+		}
+	}
+	return dst
+}
+
+// bids returns a newly allocated copy of the non-empty levels, or nil if
+// there are none.
+func (l *orderBookLevels) bids() OrderBookBids {
+	if l.count == 0 {
+		return nil
+	}
+	return l.appendBids(make(OrderBookBids, 0, l.count))
+}
+
+// bestBid returns the highest bid on the side.
+func (l *orderBookLevels) bestBid() (OrderBookBid, bool) {
+	if l.best == 0 {
+		return OrderBookBid{}, false
+	}
+	return OrderBookBid{Price: l.best, Quantity: l.quantity[l.best]}, true
+}
+
 // orderBookStreamState is kept by Book and serialized into OrderBook.
 type orderBookStreamState struct {
 	MarketID string
-	Yes      map[Cents]int
-	No       map[Cents]int
+	Yes      orderBookLevels
+	No       orderBookLevels
 }
 
 func makeOrderBookStreamState(marketID string) orderBookStreamState {
 	return orderBookStreamState{
 		MarketID: marketID,
-		Yes:      make(map[Cents]int),
-		No:       make(map[Cents]int),
 	}
 }
 
-// sortOrderBook performs an in-place sort of OrderBook.
-func sortOrderBookDirection(dir OrderBookBids) {
-	sort.Slice(dir, func(i, j int) bool {
-		return dir[i].Price < dir[j].Price
-	})
-}
+func (o *orderBookStreamState) LoadBook(book OrderBook) error {
+	o.Yes = orderBookLevels{}
+	o.No = orderBookLevels{}
 
-func clear[K comparable, V any](m map[K]V) {
-	for k := range m {
-		delete(m, k)
+	load := func(dir *orderBookLevels, bids OrderBookBids) error {
+		for _, v := range bids {
+			if !validPrice(v.Price) {
+				return fmt.Errorf("price %d out of range", v.Price)
+			}
+			if v.Quantity < 0 {
+				return fmt.Errorf("negative quantity %v at %d", v.Quantity, v.Price)
+			}
+			dir.set(v.Price, v.Quantity)
+		}
+		return nil
 	}
-}
 
-func (o *orderBookStreamState) LoadBook(book OrderBook) {
-	clear(o.Yes)
-	clear(o.No)
-
-	for _, v := range book.YesBids {
-		o.Yes[v.Price] = v.Quantity
+	err := load(&o.Yes, book.YesBids)
+	if err != nil {
+		return fmt.Errorf("yes: %w", err)
 	}
-
-	for _, v := range book.NoBids {
-		o.No[v.Price] = v.Quantity
+	err = load(&o.No, book.NoBids)
+	if err != nil {
+		return fmt.Errorf("no: %w", err)
 	}
+	return nil
 }
 
-// OrderBook returns a canonical OrderBook from the stream state.
+// OrderBook returns a canonical OrderBook from the stream state. The returned
+// book does not share memory with the state.
 func (o *orderBookStreamState) OrderBook() *StreamOrderBook {
-	ob := StreamOrderBook{
+	return &StreamOrderBook{
+		OrderBook: OrderBook{
+			YesBids: o.Yes.bids(),
+			NoBids:  o.No.bids(),
+		},
 		LoadedAt: time.Now(),
 		MarketID: o.MarketID,
 	}
+}
 
-	for k, v := range o.Yes {
-		ob.YesBids = append(ob.YesBids, OrderBookBid{Price: k, Quantity: v})
-	}
-	for k, v := range o.No {
-		ob.NoBids = append(ob.NoBids, OrderBookBid{Price: k, Quantity: v})
-	}
+// CopyOrderBook writes the stream state into dst, reusing the capacity of
+// its bid slices. Callers that retain dst across updates avoid allocating
+// once the slices have grown to the size of the book.
+func (o *orderBookStreamState) CopyOrderBook(dst *StreamOrderBook) {
+	dst.YesBids = o.Yes.appendBids(dst.YesBids[:0])
+	dst.NoBids = o.No.appendBids(dst.NoBids[:0])
+	dst.LoadedAt = time.Now()
+	dst.MarketID = o.MarketID
+}
+
+// checkIntegrity returns an error if the book is crossed or locked. Invalid
+// levels are rejected by LoadBook and ApplyDelta.
+func (o *orderBookStreamState) checkIntegrity() *IntegrityError {
+	return checkSpread(o.Yes.best, o.No.best)
+}
 
-	sortOrderBookDirection(ob.NoBids)
-	sortOrderBookDirection(ob.YesBids)
+// BestYesBid returns the highest Yes bid.
+func (o *orderBookStreamState) BestYesBid() (OrderBookBid, bool) {
+	return o.Yes.bestBid()
+}
 
-	return &ob
+// BestNoBid returns the highest No bid.
+func (o *orderBookStreamState) BestNoBid() (OrderBookBid, bool) {
+	return o.No.bestBid()
 }
 
 func (o *orderBookStreamState) ApplyDelta(side Side, price Cents, delta int) error {
-	var dir map[Cents]int
+	var dir *orderBookLevels
 	if side == Yes {
-		dir = o.Yes
+		dir = &o.Yes
 	} else if side == No {
-		dir = o.No
+		dir = &o.No
 	} else {
 		return fmt.Errorf("unknown side: %v", side)
 	}
 
-	current := dir[price]
-	current += delta
+	if !validPrice(price) {
+		return fmt.Errorf("price %d out of range", price)
+	}
+
+	current := dir.quantity[price] + delta
 	if current < 0 {
 		return fmt.Errorf("delta when below zero")
-	} else if current == 0 {
-		delete(dir, price)
-	} else {
-		dir[price] = current
 	}
+	dir.set(price, current)
 
 	return nil
 }
@@ -173,101 +944,459 @@ type StreamOrderBook struct {
 
 // Book instantiates a streaming order book feed for market.
 func (s *Feed) Book(ctx context.Context, marketTicker string, feed chan<- *StreamOrderBook) error {
-	id := 1
-	err := s.sendCommand(ctx, command{
-		ID:      1,
-		Command: "subscribe",
-		Params: commandParams{
-			Channels: []string{
-				"orderbook_delta",
-			},
-			MarketTicker: marketTicker,
-		},
+	return s.Books(ctx, []string{marketTicker}, feed)
+}
+
+// Books streams the order books of several markets over a single
+// subscription. Books are multiplexed onto feed and can be told apart by
+// their MarketID.
+//
+// Books are delivered according to the Delivery policy of the Feed.
+func (s *Feed) Books(ctx context.Context, marketTickers []string, feed chan<- *StreamOrderBook) error {
+	return s.books(ctx, marketTickers, nil, feed)
+}
+
+func (s *Feed) books(ctx context.Context, marketTickers []string, ref *streamRef, feed chan<- *StreamOrderBook) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	send := deliver(ctx, s, feed, func(book *StreamOrderBook) string {
+		return book.MarketID
 	})
-	if err != nil {
-		return err
-	}
+	return s.streamBooks(ctx, marketTickers, ref, func(ev BookEvent) {
+		// Every message produces exactly one of these.
+		if ev.Type == SnapshotEvent || ev.Type == LevelChangedEvent {
+			send(ev.Book.OrderBook())
+		}
+	})
+}
 
-	var r subscribedResponse
-	err = wsjson.Read(ctx, s.c, &r)
-	if err != nil {
-		return err
+// BookEvents instantiates a streaming order book feed for market that sends
+// the events selected by mask instead of full copies of the book. A zero mask
+// selects every event type.
+//
+// Events for a single message are sent in order: LevelChangedEvent first,
+// followed by BestBidChangedEvent or BestAskChangedEvent if the delta moved
+// the top of the book. Events are delivered according to the Delivery policy
+// of the Feed.
+func (s *Feed) BookEvents(ctx context.Context, marketTicker string, mask BookEventType, events chan<- BookEvent) error {
+	if mask == 0 {
+		mask = AllBookEvents
 	}
 
-	if r.Type != "subscribed" {
-		return fmt.Errorf("unexpected message: %+v", r)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	send := deliver(ctx, s, events, func(ev BookEvent) string {
+		return ev.MarketID + "/" + ev.Type.String()
+	})
+	return s.streamBooks(ctx, []string{marketTicker}, nil, func(ev BookEvent) {
+		if ev.Type&mask != 0 {
+			send(ev)
+		}
+	})
+}
+
+// checkBook applies the integrity policy of the Feed to a streamed book.
+func (s *Feed) checkBook(marketID string, ierr *IntegrityError) (resync bool, err error) {
+	if ierr == nil {
+		return false, nil
+	}
+	if s.OnIntegrityError != nil {
+		s.OnIntegrityError(marketID, ierr)
+	}
+	switch s.IntegrityAction {
+	case FailOnIntegrity:
+		return false, ierr
+	case ResyncOnIntegrity:
+		return true, nil
+	default:
+		return false, nil
 	}
+}
+
+func (s *Feed) checksIntegrity() bool {
+	return s.IntegrityAction != IgnoreIntegrity || s.OnIntegrityError != nil
+}
 
-	if r.ID != id {
-		return fmt.Errorf("unexpected id: %+v", id)
+// integrityCheck returns how deltas are checked under the integrity policy.
+// Deltas are rejected before they become visible unless the policy only
+// reports.
+func (s *Feed) integrityCheck() integrityCheck {
+	switch {
+	case s.IntegrityAction != IgnoreIntegrity:
+		return rejectCheck
+	case s.OnIntegrityError != nil:
+		return reportCheck
+	default:
+		return noCheck
 	}
+}
 
-	sid := r.Msg.Sid
-	wantSeq := 1
+// maxSnapshotResyncs is the number of consecutive times ResyncOnIntegrity
+// resyncs a book because of its snapshot.
+const maxSnapshotResyncs = 3
 
-	orderBookState := makeOrderBookStreamState(marketTicker)
+// errResync ends a subscription that must be replaced to receive fresh
+// snapshots.
+var errResync = errors.New("resync")
 
+// marketParams subscribes to channel for marketTickers. Some channels accept
+// no tickers to subscribe to every market.
+func marketParams(channel string, marketTickers []string) commandParams {
+	params := commandParams{
+		Channels: []string{
+			channel,
+		},
+	}
+	if len(marketTickers) == 1 {
+		params.MarketTicker = marketTickers[0]
+	} else {
+		params.MarketTickers = marketTickers
+	}
+	return params
+}
+
+// stream subscribes with params and passes every message of the
+// subscription to h until ctx is done or the subscription fails. The
+// subscription is replaced whenever it needs to resync.
+func (s *Feed) stream(ctx context.Context, params commandParams, h subscriptionHandler) error {
+	if h.stats == nil {
+		h.stats = newStreamStats()
+	}
 	for {
-		_, message, err := s.c.Read(ctx)
-		if err != nil {
-			return fmt.Errorf("read message: %w", err)
+		sub := newSubscription(params, h)
+		if h.ref != nil {
+			s.mu.Lock()
+			h.ref.sub = sub
+			s.mu.Unlock()
 		}
-
-		var header subscriptionMessageHeader
-		err = json.Unmarshal(message, &header)
+		err := s.subscribeConnected(ctx, sub)
 		if err != nil {
-			return fmt.Errorf("read header: %+v", err)
+			return err
+		}
+		err = sub.wait(ctx)
+		s.dropSubscription(sub)
+		if errors.Is(err, errResync) {
+			// The replacement keeps any updates to the subscription.
+			s.mu.Lock()
+			params = sub.params
+			s.mu.Unlock()
+			continue
 		}
+		return err
+	}
+}
 
-		if header.Sid != sid {
-			return fmt.Errorf("unexpected sid %v", header.Sid)
+// streamBooks subscribes to the order books of marketTickers. emit is called
+// from the reader goroutine after each message has been applied. ref, if
+// set, follows the subscription of the stream.
+func (s *Feed) streamBooks(ctx context.Context, marketTickers []string, ref *streamRef, emit func(BookEvent)) error {
+	if len(marketTickers) == 0 {
+		return fmt.Errorf("no market tickers")
+	}
+
+	stats := newStreamStats()
+
+	// books is only changed by the reader goroutine, under booksMu so that
+	// the verifier may list it.
+	var booksMu sync.Mutex
+	books := make(map[string]*StreamBook, len(marketTickers))
+	for _, ticker := range marketTickers {
+		books[ticker] = newStreamBook(ticker)
+	}
+	bookFor := func(marketID, marketTicker []byte) (*StreamBook, error) {
+		if len(marketTicker) == 0 {
+			marketTicker = marketID
+		}
+		if len(marketTicker) == 0 && len(books) == 1 {
+			for _, book := range books {
+				return book, nil
+			}
 		}
-		if header.Seq != wantSeq {
-			return fmt.Errorf("unexpected sequence %v, want %v", header.Seq, wantSeq)
+		book, ok := books[string(marketTicker)]
+		if !ok {
+			return nil, fmt.Errorf("unexpected market %q", marketTicker)
 		}
-		wantSeq++
+		return book, nil
+	}
+
+	// warm holds the saved books that are loaded once the exchange
+	// acknowledges the first subscription of the stream, and unconfirmed
+	// holds the loaded books that await their fresh snapshot.
+	var (
+		warm        map[string]SavedBook
+		unconfirmed = make(map[string]bool)
+	)
+	if s.WarmStart != nil {
+		warm = s.WarmStart.books(time.Now())
+	}
+
+	// snapshotResyncs counts the consecutive resyncs caused by the snapshots
+	// of each market.
+	snapshotResyncs := make(map[string]int)
+
+	// Events are held back until the book passes its integrity check.
+	var (
+		pending []BookEvent
+		hold    = func(ev BookEvent) {
+			pending = append(pending, ev)
+		}
+	)
+
+	// Payloads are decoded into the same messages, so that snapshots reuse
+	// their bids.
+	var (
+		snapshot bookSnapshotMsg
+		delta    bookDeltaMsg
+	)
+
+	handle := func(header subscriptionMessageHeader, msg []byte) error {
+		pending = pending[:0]
+
+		var (
+			book *StreamBook
+			ierr *IntegrityError
+		)
 
 		switch header.Type {
 		case "orderbook_snapshot":
-			var snapshot orderBookSnapshot
-			err = json.Unmarshal(message, &snapshot)
+			start := time.Now()
+			err := snapshot.decode(msg)
+			stats.decoded(start)
+			if err != nil {
+				return fmt.Errorf("decode snapshot: %w", err)
+			}
+			book, err = bookFor(snapshot.MarketID, snapshot.MarketTicker)
 			if err != nil {
-				return fmt.Errorf("unmarshal snapshot: %w", err)
+				return err
 			}
 			ob := OrderBook{
-				YesBids: snapshot.Msg.Yes,
-				NoBids:  snapshot.Msg.No,
+				YesBids: snapshot.Yes,
+				NoBids:  snapshot.No,
+			}
+			if s.checksIntegrity() {
+				// Invalid levels make LoadBook fail, so the raw snapshot
+				// is checked before loading.
+				ierr, _ = ob.CheckIntegrity().(*IntegrityError)
+				needResync, err := s.checkBook(book.MarketID(), ierr)
+				if err != nil {
+					return err
+				}
+				if ierr == nil {
+					delete(snapshotResyncs, book.MarketID())
+				}
+				if needResync {
+					// A book that the exchange itself sends crossed would
+					// resync forever, so it is loaded as sent after
+					// maxSnapshotResyncs attempts.
+					snapshotResyncs[book.MarketID()]++
+					if snapshotResyncs[book.MarketID()] <= maxSnapshotResyncs {
+						return errResync
+					}
+					if ierr.Problem == InvalidPrice || ierr.Problem == InvalidQuantity {
+						return ierr
+					}
+				}
+			}
+			if unconfirmed[book.MarketID()] {
+				delete(unconfirmed, book.MarketID())
+				state, _ := book.snapshot()
+				var touched [2][100]bool
+				diff := diffBook(&state, &touched, &ob)
+				if len(diff) == 0 {
+					// The saved book is current, so consumers already have
+					// it.
+					err = book.loadSnapshot(header.Seq, ob, func(BookEvent) {})
+					if err != nil {
+						return fmt.Errorf("load snapshot: %w", err)
+					}
+					return nil
+				}
+				if s.WarmStart.OnStale != nil {
+					s.WarmStart.OnStale(BookDivergence{
+						MarketID: book.MarketID(),
+						Levels:   diff,
+					})
+				}
+			}
+			err = book.loadSnapshot(header.Seq, ob, hold)
+			if err != nil {
+				return fmt.Errorf("load snapshot: %w", err)
 			}
-			orderBookState.LoadBook(ob)
-			feed <- orderBookState.OrderBook()
 		case "orderbook_delta":
-			var delta orderBookDelta
-			err = json.Unmarshal(message, &delta)
+			start := time.Now()
+			err := delta.decode(msg)
+			stats.decoded(start)
+			if err != nil {
+				return fmt.Errorf("decode delta: %w", err)
+			}
+			book, err = bookFor(delta.MarketID, delta.MarketTicker)
 			if err != nil {
-				return fmt.Errorf("unmarshal delta: %w", err)
+				return err
 			}
-			err = orderBookState.ApplyDelta(
-				delta.Msg.Side, delta.Msg.Price, delta.Msg.Delta,
-			)
+			ierr, err = book.applyDelta(header.Seq, BookDelta{
+				Side:  delta.Side,
+				Price: delta.Price,
+				Delta: delta.Delta,
+			}, s.integrityCheck(), hold)
 			if err != nil {
 				return fmt.Errorf("apply delta: %w", err)
 			}
-			feed <- orderBookState.OrderBook()
-		case "error":
-			var errMsg errorMessage
-			err = json.Unmarshal(message, &errMsg)
+			needResync, err := s.checkBook(book.MarketID(), ierr)
 			if err != nil {
-				return fmt.Errorf("unmarshal error: %w", err)
+				return err
+			}
+			if needResync {
+				return errResync
 			}
-			return fmt.Errorf("error message (%v): %v", errMsg.Msg.Code, errMsg.Msg.Msg)
 		default:
 			return fmt.Errorf("unexpected type %q", header.Type)
 		}
+
+		for _, ev := range pending {
+			emit(ev)
+		}
+		return nil
+	}
+
+	reset := func() {
+		for _, book := range books {
+			book.reset()
+		}
+		for ticker := range unconfirmed {
+			delete(unconfirmed, ticker)
+		}
+	}
+
+	// started loads the saved books while the exchange prepares the fresh
+	// snapshots.
+	started := func() {
+		if warm == nil {
+			return
+		}
+		for _, book := range sortedBooks(&booksMu, books) {
+			saved, ok := warm[book.MarketID()]
+			if !ok {
+				continue
+			}
+			err := book.loadSnapshot(0, OrderBook{
+				YesBids: saved.YesBids,
+				NoBids:  saved.NoBids,
+			}, emit)
+			if err != nil {
+				// Saved books that fail to load wait for their snapshot.
+				book.reset()
+				continue
+			}
+			unconfirmed[book.MarketID()] = true
+		}
+		warm = nil
+	}
+
+	update := func(action UpdateAction, marketTickers []string) {
+		booksMu.Lock()
+		defer booksMu.Unlock()
+		for _, ticker := range marketTickers {
+			switch action {
+			case AddMarkets:
+				if books[ticker] == nil {
+					books[ticker] = newStreamBook(ticker)
+				}
+			case DeleteMarkets:
+				delete(books, ticker)
+			}
+		}
 	}
+
+	if ref == nil {
+		ref = &streamRef{}
+	}
+	listBooks := func() []*StreamBook {
+		return sortedBooks(&booksMu, books)
+	}
+	bs := &bookStream{books: listBooks}
+	s.mu.Lock()
+	s.bookStreams[bs] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.bookStreams, bs)
+		s.mu.Unlock()
+	}()
+
+	if s.Verify != nil && s.client != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.verifyBooks(ctx, *s.Verify, ref, listBooks)
+	}
+
+	return s.stream(ctx, marketParams("orderbook_delta", marketTickers), subscriptionHandler{
+		sequenced: true,
+		handle:    handle,
+		reset:     reset,
+		started:   started,
+		update:    update,
+		stats:     stats,
+		ref:       ref,
+	})
+}
+
+// Done returns a channel that is closed once the Feed stops reading, either
+// because it was closed or because the connection failed.
+func (s *Feed) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error that stopped the Feed, if any.
+func (s *Feed) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
 }
 
 func (f *Feed) Close() error {
-	return f.c.Close(websocket.StatusNormalClosure, "")
+	f.mu.Lock()
+	f.closed = true
+	c := f.c
+	f.mu.Unlock()
+
+	var err error
+	if c != nil {
+		err = c.Close(websocket.StatusNormalClosure, "")
+	}
+	f.cancel()
+	return err
+}
+
+// newFeed starts the reader goroutine of a Feed on an open connection. dial
+// is used to reconnect.
+func newFeed(c *websocket.Conn, dial func(context.Context) (*websocket.Conn, error), client *Client) *Feed {
+	f, ctx := makeFeed(c, dial, client)
+	go f.read(ctx)
+	go f.resubscribeLoop(ctx)
+	return f
+}
+
+// makeFeed returns a Feed without starting it. ctx is done once the Feed is
+// closed.
+func makeFeed(c *websocket.Conn, dial func(context.Context) (*websocket.Conn, error), client *Client) (f *Feed, ctx context.Context) {
+	ctx, cancel := context.WithCancel(context.Background())
+	f = &Feed{
+		dial:        dial,
+		client:      client,
+		c:           c,
+		lastMessage: time.Now(),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+		resubscribe: make(chan struct{}, 1),
+		pending:     make(map[int]*pendingCommand),
+		subs:        make(map[int]*subscription),
+		active:      make(map[*subscription]struct{}),
+		bookStreams: make(map[*bookStream]struct{}),
+		messages:    make(map[string]uint64),
+	}
+	return f, ctx
 }
 
 // OpenFeed creates a new market data streaming connection.
@@ -283,18 +1412,25 @@ func (c *Client) OpenFeed(ctx context.Context) (*Feed, error) {
 	u.Scheme = "wss"
 	u.Path = "trade-api/ws/v2"
 
-	conn, resp, err := websocket.Dial(ctx,
-		u.String(),
-		&websocket.DialOptions{
-			HTTPClient: c.httpClient,
-		},
-	)
-	if err != nil {
-		return nil, fmt.Errorf("dial %q: %w", u.String(), err)
-	}
-	if resp.StatusCode != http.StatusSwitchingProtocols {
-		return nil, fmt.Errorf("websocket refused: %v", resp.Status)
+	dial := func(ctx context.Context) (*websocket.Conn, error) {
+		conn, resp, err := websocket.Dial(ctx,
+			u.String(),
+			&websocket.DialOptions{
+				HTTPClient: c.httpClient,
+			},
+		)
+		if err != nil {
+			return nil, fmt.Errorf("dial %q: %w", u.String(), err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			return nil, fmt.Errorf("websocket refused: %v", resp.Status)
+		}
+		return conn, nil
 	}
 
-	return &Feed{c: conn}, nil
+	conn, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newFeed(conn, dial, c), nil
 }
@@ -2,14 +2,21 @@ package kalshi
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"reflect"
 	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
 )
 
 func Test_orderBookStreamState(t *testing.T) {
@@ -67,7 +74,7 @@ func Test_orderBookStreamState(t *testing.T) {
 	})
 
 	// Load book
-	sob.LoadBook(OrderBook{
+	err = sob.LoadBook(OrderBook{
 		YesBids: []OrderBookBid{
 			{19, 8},
 		},
@@ -75,11 +82,446 @@ func Test_orderBookStreamState(t *testing.T) {
 			{20, 9},
 		},
 	})
+	require.NoError(t, err)
 	requireBook([]OrderBookBid{
 		{19, 8},
 	}, []OrderBookBid{
 		{20, 9},
 	})
+
+	// Best bids are tracked through deltas.
+	best, ok := sob.BestYesBid()
+	require.True(t, ok)
+	require.Equal(t, OrderBookBid{19, 8}, best)
+
+	err = sob.ApplyDelta("yes", 30, 1)
+	require.NoError(t, err)
+	best, _ = sob.BestYesBid()
+	require.Equal(t, OrderBookBid{30, 1}, best)
+
+	err = sob.ApplyDelta("yes", 30, -1)
+	require.NoError(t, err)
+	best, _ = sob.BestYesBid()
+	require.Equal(t, OrderBookBid{19, 8}, best)
+
+	err = sob.ApplyDelta("no", 20, -9)
+	require.NoError(t, err)
+	_, ok = sob.BestNoBid()
+	require.False(t, ok)
+
+	// Invalid deltas
+	require.Error(t, sob.ApplyDelta("no", 20, -1))
+	require.Error(t, sob.ApplyDelta("no", 100, 1))
+	require.Error(t, sob.ApplyDelta("no", 0, 1))
+	require.Error(t, sob.ApplyDelta("maybe", 10, 1))
+	require.Error(t, sob.LoadBook(OrderBook{YesBids: OrderBookBids{{100, 1}}}))
+}
+
+func Test_orderBookStreamState_CopyOrderBook(t *testing.T) {
+	// AllocsPerRun cannot be used in parallel tests.
+	sob := makeOrderBookStreamState("duh")
+	for p := Cents(1); p < 99; p += 2 {
+		require.NoError(t, sob.ApplyDelta(Yes, p, int(p)))
+		require.NoError(t, sob.ApplyDelta(No, p+1, int(p)))
+	}
+
+	var book StreamOrderBook
+	sob.CopyOrderBook(&book)
+	require.Equal(t, sob.OrderBook().OrderBook, book.OrderBook)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		sob.CopyOrderBook(&book)
+	})
+	require.Zero(t, allocs)
+}
+
+// mapOrderBookStreamState is the previous map-based stream state, kept to
+// benchmark against.
+type mapOrderBookStreamState struct {
+	Yes map[Cents]int
+	No  map[Cents]int
+}
+
+func (o *mapOrderBookStreamState) ApplyDelta(side Side, price Cents, delta int) {
+	dir := o.Yes
+	if side == No {
+		dir = o.No
+	}
+	current := dir[price] + delta
+	if current == 0 {
+		delete(dir, price)
+	} else {
+		dir[price] = current
+	}
+}
+
+func (o *mapOrderBookStreamState) OrderBook() *StreamOrderBook {
+	var ob StreamOrderBook
+	for k, v := range o.Yes {
+		ob.YesBids = append(ob.YesBids, OrderBookBid{Price: k, Quantity: v})
+	}
+	for k, v := range o.No {
+		ob.NoBids = append(ob.NoBids, OrderBookBid{Price: k, Quantity: v})
+	}
+	sort.Slice(ob.YesBids, func(i, j int) bool {
+		return ob.YesBids[i].Price < ob.YesBids[j].Price
+	})
+	sort.Slice(ob.NoBids, func(i, j int) bool {
+		return ob.NoBids[i].Price < ob.NoBids[j].Price
+	})
+	return &ob
+}
+
+// benchmarkDeltas returns a deterministic stream of deltas resembling a busy
+// market with activity concentrated near the top of the book.
+func benchmarkDeltas() []orderBookDelta {
+	deltas := make([]orderBookDelta, 1024)
+	for i := range deltas {
+		d := &deltas[i].Msg
+		d.Side = SideBool(i%2 == 0)
+		d.Price = Cents(30 + (i*7)%40)
+		d.Delta = 10
+		if (i/64)%2 == 1 {
+			d.Delta = -10
+		}
+	}
+	return deltas
+}
+
+func BenchmarkOrderBookStreamState(b *testing.B) {
+	deltas := benchmarkDeltas()
+	seed := OrderBook{}
+	for p := Cents(1); p < 100; p++ {
+		seed.YesBids = append(seed.YesBids, OrderBookBid{p, 1000})
+		seed.NoBids = append(seed.NoBids, OrderBookBid{p, 1000})
+	}
+
+	b.Run("Array", func(b *testing.B) {
+		sob := makeOrderBookStreamState("bench")
+		require.NoError(b, sob.LoadBook(seed))
+		var book StreamOrderBook
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			d := deltas[i%len(deltas)].Msg
+			_ = sob.ApplyDelta(d.Side, d.Price, d.Delta)
+			sob.CopyOrderBook(&book)
+		}
+	})
+
+	b.Run("ArrayCopy", func(b *testing.B) {
+		sob := makeOrderBookStreamState("bench")
+		require.NoError(b, sob.LoadBook(seed))
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			d := deltas[i%len(deltas)].Msg
+			_ = sob.ApplyDelta(d.Side, d.Price, d.Delta)
+			_ = sob.OrderBook()
+		}
+	})
+
+	b.Run("Map", func(b *testing.B) {
+		sob := mapOrderBookStreamState{
+			Yes: make(map[Cents]int),
+			No:  make(map[Cents]int),
+		}
+		for _, v := range seed.YesBids {
+			sob.Yes[v.Price] = v.Quantity
+		}
+		for _, v := range seed.NoBids {
+			sob.No[v.Price] = v.Quantity
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			d := deltas[i%len(deltas)].Msg
+			sob.ApplyDelta(d.Side, d.Price, d.Delta)
+			_ = sob.OrderBook()
+		}
+	})
+}
+
+// testFeed returns a Feed connected to a local websocket stand-in for the
+// exchange. serve drives every connection, including reconnects.
+func testFeed(t *testing.T, serve func(ctx context.Context, c *websocket.Conn)) *Feed {
+	t.Helper()
+
+	// serveCtx is canceled before the Feed is closed so that serve returns
+	// and the close handshake completes promptly.
+	serveCtx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer c.Close(websocket.StatusInternalError, "")
+		serve(serveCtx, c)
+		c.Close(websocket.StatusNormalClosure, "")
+	}))
+
+	dial := func(ctx context.Context) (*websocket.Conn, error) {
+		c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+		return c, err
+	}
+	c, err := dial(context.Background())
+	require.NoError(t, err)
+	f := newFeed(c, dial, nil)
+	t.Cleanup(func() {
+		cancel()
+		_ = f.Close()
+		srv.Close()
+	})
+	return f
+}
+
+// readCommand reads the next command sent by the Feed. Errors are not
+// reported once ctx is done, since the test is over.
+func readCommand(ctx context.Context, t *testing.T, c *websocket.Conn) command {
+	var cmd command
+	err := wsjson.Read(ctx, c, &cmd)
+	if err != nil && ctx.Err() == nil {
+		t.Errorf("read command: %v", err)
+	}
+	return cmd
+}
+
+// writeMessages writes raw messages to the Feed. Errors are not reported
+// once ctx is done, since the test may end while the last message is still
+// being written.
+func writeMessages(ctx context.Context, t *testing.T, c *websocket.Conn, msgs ...string) {
+	for _, msg := range msgs {
+		err := c.Write(ctx, websocket.MessageText, []byte(msg))
+		if err != nil {
+			if ctx.Err() == nil {
+				t.Errorf("write: %v", err)
+			}
+			return
+		}
+	}
+}
+
+func TestFeedIntegrity(t *testing.T) {
+	t.Parallel()
+
+	const (
+		subscribed = `{"id": %d, "type": "subscribed", "msg": {"channel": "orderbook_delta", "sid": %d}}`
+		snapshot   = `{"type": "orderbook_snapshot", "sid": %d, "seq": 1, "msg": {"market_ticker": "DUH", "yes": [[40, 10]], "no": [[50, 10]]}}`
+		crossing   = `{"type": "orderbook_delta", "sid": 1, "seq": 2, "msg": {"market_ticker": "DUH", "price": 70, "delta": 5, "side": "yes"}}`
+	)
+
+	t.Run("Resync", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			cmd := readCommand(ctx, t, c)
+			assert.Equal(t, "subscribe", cmd.Command)
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(subscribed, cmd.ID, 1),
+				fmt.Sprintf(snapshot, 1),
+				crossing,
+			)
+
+			cmd = readCommand(ctx, t, c)
+			assert.Equal(t, "unsubscribe", cmd.Command)
+			assert.Equal(t, []int{1}, cmd.Params.Sids)
+			writeMessages(ctx, t, c,
+				// Stale messages are dropped.
+				`{"type": "orderbook_delta", "sid": 1, "seq": 3, "msg": {"market_ticker": "DUH", "price": 71, "delta": 5, "side": "yes"}}`,
+				fmt.Sprintf(`{"id": %d, "type": "unsubscribed", "sid": 1}`, cmd.ID),
+			)
+
+			cmd = readCommand(ctx, t, c)
+			assert.Equal(t, "subscribe", cmd.Command)
+			assert.Equal(t, "DUH", cmd.Params.MarketTicker)
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(subscribed, cmd.ID, 2),
+				fmt.Sprintf(snapshot, 2),
+			)
+			<-ctx.Done()
+		})
+
+		var problems []IntegrityProblem
+		f.IntegrityAction = ResyncOnIntegrity
+		f.OnIntegrityError = func(marketID string, err *IntegrityError) {
+			assert.Equal(t, "DUH", marketID)
+			problems = append(problems, err.Problem)
+		}
+
+		events := make(chan BookEvent)
+		go func() {
+			_ = f.BookEvents(ctx, "DUH", 0, events)
+		}()
+
+		ev := <-events
+		require.Equal(t, SnapshotEvent, ev.Type)
+
+		// The crossing delta is withheld and the book is reloaded.
+		ev = <-events
+		require.Equal(t, SnapshotEvent, ev.Type)
+		require.Equal(t, 1, ev.Seq)
+		require.Equal(t, OrderBookBids{{40, 10}}, ev.Book.OrderBook().YesBids)
+		require.Equal(t, []IntegrityProblem{CrossedBook}, problems)
+	})
+
+	t.Run("CrossedSnapshot", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var subscribes atomic.Int64
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			// The exchange keeps sending the same crossed book.
+			for sid := 1; sid <= maxSnapshotResyncs+1; sid++ {
+				if sid > 1 {
+					cmd := readCommand(ctx, t, c)
+					assert.Equal(t, "unsubscribe", cmd.Command)
+					writeMessages(ctx, t, c, fmt.Sprintf(`{"id": %d, "type": "unsubscribed", "sid": %d}`, cmd.ID, sid-1))
+				}
+				cmd := readCommand(ctx, t, c)
+				assert.Equal(t, "subscribe", cmd.Command)
+				subscribes.Add(1)
+				writeMessages(ctx, t, c,
+					fmt.Sprintf(subscribed, cmd.ID, sid),
+					fmt.Sprintf(`{"type": "orderbook_snapshot", "sid": %d, "seq": 1, "msg": {"market_ticker": "DUH", "yes": [[60, 10]], "no": [[50, 10]]}}`, sid),
+				)
+			}
+			// Deltas to the admitted book are applied.
+			writeMessages(ctx, t, c, fmt.Sprintf(
+				`{"type": "orderbook_delta", "sid": %d, "seq": 2, "msg": {"market_ticker": "DUH", "price": 10, "delta": 5, "side": "yes"}}`,
+				maxSnapshotResyncs+1,
+			))
+			<-ctx.Done()
+		})
+		var problems atomic.Int64
+		f.IntegrityAction = ResyncOnIntegrity
+		f.OnIntegrityError = func(string, *IntegrityError) {
+			problems.Add(1)
+		}
+
+		books := make(chan *StreamOrderBook, 1)
+		go func() {
+			_ = f.Book(ctx, "DUH", books)
+		}()
+		// The book is sent as is after three resyncs, and the delta that
+		// follows doesn't resync it again.
+		require.Equal(t, OrderBookBids{{60, 10}}, (<-books).YesBids)
+		require.Equal(t, OrderBookBids{{10, 5}, {60, 10}}, (<-books).YesBids)
+		require.Equal(t, int64(maxSnapshotResyncs+1), subscribes.Load())
+		require.Equal(t, int64(maxSnapshotResyncs+1), problems.Load())
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			cmd := readCommand(ctx, t, c)
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(subscribed, cmd.ID, 1),
+				fmt.Sprintf(snapshot, 1),
+				crossing,
+			)
+			<-ctx.Done()
+		})
+		f.IntegrityAction = FailOnIntegrity
+
+		books := make(chan *StreamOrderBook, 2)
+		err := f.Book(ctx, "DUH", books)
+		var ierr *IntegrityError
+		require.ErrorAs(t, err, &ierr)
+		require.Equal(t, CrossedBook, ierr.Problem)
+		require.Len(t, books, 1)
+	})
+}
+
+func TestFeedMultiplex(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		snapshot = `{"type": "orderbook_snapshot", "sid": %d, "seq": 1, "msg": {"market_ticker": %q, "yes": [[%d, 1]], "no": []}}`
+		delta    = `{"type": "orderbook_delta", "sid": %d, "seq": 2, "msg": {"market_ticker": %q, "price": %d, "delta": 1, "side": "no"}}`
+	)
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		// Accept every subscription before sending any data so that the
+		// messages of different subscriptions interleave.
+		var (
+			ids     []int
+			tickers [][]string
+		)
+		for i := 0; i < 3; i++ {
+			cmd := readCommand(ctx, t, c)
+			assert.Equal(t, "subscribe", cmd.Command)
+			ids = append(ids, cmd.ID)
+			if cmd.Params.MarketTicker != "" {
+				tickers = append(tickers, []string{cmd.Params.MarketTicker})
+			} else {
+				tickers = append(tickers, cmd.Params.MarketTickers)
+			}
+			writeMessages(ctx, t, c, fmt.Sprintf(
+				`{"id": %d, "type": "subscribed", "msg": {"channel": "orderbook_delta", "sid": %d}}`,
+				cmd.ID, i+1,
+			))
+		}
+		sort.Ints(ids)
+		assert.Equal(t, []int{1, 2, 3}, ids)
+
+		for sid, ts := range tickers {
+			for seq, ticker := range ts {
+				writeMessages(ctx, t, c,
+					strings.Replace(fmt.Sprintf(snapshot, sid+1, ticker, len(ticker)), `"seq": 1`, fmt.Sprintf(`"seq": %d`, seq+1), 1),
+				)
+			}
+		}
+		for sid, ts := range tickers {
+			if len(ts) == 1 {
+				writeMessages(ctx, t, c, fmt.Sprintf(delta, sid+1, ts[0], 10))
+			}
+		}
+		<-ctx.Done()
+	})
+
+	// Consumers are buffered since a blocked consumer stalls the reader.
+	var (
+		a    = make(chan *StreamOrderBook, 2)
+		b    = make(chan *StreamOrderBook, 2)
+		many = make(chan *StreamOrderBook, 2)
+	)
+	go func() {
+		_ = f.Book(ctx, "A", a)
+	}()
+	go func() {
+		_ = f.Book(ctx, "BB", b)
+	}()
+	go func() {
+		_ = f.Books(ctx, []string{"CCC", "DDDD"}, many)
+	}()
+
+	book := <-a
+	require.Equal(t, "A", book.MarketID)
+	require.Equal(t, OrderBookBids{{1, 1}}, book.YesBids)
+	book = <-a
+	require.Equal(t, OrderBookBids{{10, 1}}, book.NoBids)
+
+	book = <-b
+	require.Equal(t, "BB", book.MarketID)
+	require.Equal(t, OrderBookBids{{2, 1}}, book.YesBids)
+	book = <-b
+	require.Equal(t, OrderBookBids{{10, 1}}, book.NoBids)
+
+	book = <-many
+	require.Equal(t, "CCC", book.MarketID)
+	require.Equal(t, OrderBookBids{{3, 1}}, book.YesBids)
+	book = <-many
+	require.Equal(t, "DDDD", book.MarketID)
+	require.Equal(t, OrderBookBids{{4, 1}}, book.YesBids)
 }
 
 func highestVolumeMarkets(ctx context.Context, t *testing.T, client *Client) []Market {
@@ -152,7 +594,7 @@ func TestFeed(t *testing.T) {
 
 		// The polling API can lag behind sometimes.
 		assert.Eventually(t, func() bool {
-			wantBook, err = client.MarketOrderBook(ctx, marketTicker)
+			wantBook, err = client.MarketOrderBook(ctx, marketTicker, MarketOrderBookRequest{})
 			require.NoError(t, err)
 
 			return reflect.DeepEqual(wantBook.NoBids, gotBook.NoBids) && reflect.DeepEqual(wantBook.YesBids, gotBook.YesBids)
@@ -0,0 +1,241 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+)
+
+// FeedHandler receives the streams of a Feed through callbacks instead of
+// channels. See Feed.Handle.
+type FeedHandler interface {
+	// OnSnapshot is called when a book is loaded from a snapshot.
+	OnSnapshot(ev BookEvent)
+	// OnDelta is called for every level change of a book.
+	OnDelta(ev BookEvent)
+	OnTrade(trade Trade)
+	OnTicker(ticker Ticker)
+	OnFill(fill Fill)
+	// OnError is called with the error that ends Handle, unless the
+	// context of Handle is done.
+	OnError(err error)
+	// OnReconnect is called once the Feed replaced a connection that
+	// failed with cause. Books restart with OnSnapshot once their
+	// subscriptions are restored.
+	OnReconnect(cause error)
+}
+
+// HandlerSubscription selects the streams of Feed.Handle.
+type HandlerSubscription struct {
+	// MarketTickers are the markets to follow. Channels other than books
+	// follow every market if none are given.
+	MarketTickers []string
+
+	Books   bool
+	Trades  bool
+	Tickers bool
+	// Fills requires the Client that opened the Feed to be logged in.
+	Fills bool
+}
+
+// Handle streams the channels selected by sub to h until ctx is done or a
+// stream fails.
+//
+// Every callback except OnError is called by the reader goroutine of the
+// Feed, so callbacks are never concurrent and must not block. Callbacks for
+// a market are made in the order the exchange sent its messages, across
+// channels. Every book starts with OnSnapshot, followed by OnDelta in
+// sequence order. Delivery policies do not apply to handlers.
+func (s *Feed) Handle(ctx context.Context, h FeedHandler, sub HandlerSubscription) error {
+	if !sub.Books && !sub.Trades && !sub.Tickers && !sub.Fills {
+		return fmt.Errorf("no channels")
+	}
+	if sub.Books && len(sub.MarketTickers) == 0 {
+		return fmt.Errorf("books require market tickers")
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// cause is only accessed by the reader goroutine.
+	var cause error
+	stop := s.listenState(func(state FeedState, err error) {
+		switch state {
+		case FeedReconnecting:
+			cause = err
+		case FeedConnected:
+			h.OnReconnect(cause)
+		}
+	})
+	defer stop()
+
+	var (
+		streams int
+		errs    = make(chan error, 4)
+	)
+	start := func(stream func() error) {
+		streams++
+		go func() {
+			errs <- stream()
+		}()
+	}
+
+	tickers := sub.MarketTickers
+	if sub.Books {
+		start(func() error {
+			return s.streamBooks(ctx, tickers, nil, func(ev BookEvent) {
+				switch ev.Type {
+				case SnapshotEvent:
+					h.OnSnapshot(ev)
+				case LevelChangedEvent:
+					h.OnDelta(ev)
+				}
+			})
+		})
+	}
+	if sub.Trades {
+		start(func() error {
+			return streamFunc(ctx, s, marketParams("trade", tickers), false, "trade", tradeMessage.Trade, h.OnTrade)
+		})
+	}
+	if sub.Tickers {
+		start(func() error {
+			return streamFunc(ctx, s, marketParams("ticker", tickers), false, "ticker", identity[Ticker], h.OnTicker)
+		})
+	}
+	if sub.Fills {
+		start(func() error {
+			return streamFunc(ctx, s, marketParams("fill", tickers), false, "fill", fillMessage.Fill, h.OnFill)
+		})
+	}
+
+	err := <-errs
+	cancel()
+	for i := 1; i < streams; i++ {
+		<-errs
+	}
+
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	if err == nil {
+		// A stream was unsubscribed.
+		return nil
+	}
+	h.OnError(err)
+	return err
+}
+
+// FeedHandlerFuncs is a FeedHandler that calls its functions. Nil functions
+// are skipped.
+type FeedHandlerFuncs struct {
+	Snapshot  func(ev BookEvent)
+	Delta     func(ev BookEvent)
+	Trade     func(trade Trade)
+	Ticker    func(ticker Ticker)
+	Fill      func(fill Fill)
+	Error     func(err error)
+	Reconnect func(cause error)
+}
+
+var _ FeedHandler = FeedHandlerFuncs{}
+
+func (f FeedHandlerFuncs) OnSnapshot(ev BookEvent) {
+	if f.Snapshot != nil {
+		f.Snapshot(ev)
+	}
+}
+
+func (f FeedHandlerFuncs) OnDelta(ev BookEvent) {
+	if f.Delta != nil {
+		f.Delta(ev)
+	}
+}
+
+func (f FeedHandlerFuncs) OnTrade(trade Trade) {
+	if f.Trade != nil {
+		f.Trade(trade)
+	}
+}
+
+func (f FeedHandlerFuncs) OnTicker(ticker Ticker) {
+	if f.Ticker != nil {
+		f.Ticker(ticker)
+	}
+}
+
+func (f FeedHandlerFuncs) OnFill(fill Fill) {
+	if f.Fill != nil {
+		f.Fill(fill)
+	}
+}
+
+func (f FeedHandlerFuncs) OnError(err error) {
+	if f.Error != nil {
+		f.Error(err)
+	}
+}
+
+func (f FeedHandlerFuncs) OnReconnect(cause error) {
+	if f.Reconnect != nil {
+		f.Reconnect(cause)
+	}
+}
+
+// ChannelHandler is a FeedHandler that sends every callback to a channel,
+// so that a single Handle can feed consumers written for the channel
+// methods of Feed. Nil channels are skipped. Sends block the reader of the
+// Feed, so channels should be buffered and drained promptly.
+type ChannelHandler struct {
+	// Books receives snapshots and level changes.
+	Books      chan<- BookEvent
+	Trades     chan<- Trade
+	Tickers    chan<- Ticker
+	Fills      chan<- Fill
+	Errors     chan<- error
+	Reconnects chan<- error
+}
+
+var _ FeedHandler = ChannelHandler{}
+
+func (c ChannelHandler) OnSnapshot(ev BookEvent) {
+	if c.Books != nil {
+		c.Books <- ev
+	}
+}
+
+func (c ChannelHandler) OnDelta(ev BookEvent) {
+	if c.Books != nil {
+		c.Books <- ev
+	}
+}
+
+func (c ChannelHandler) OnTrade(trade Trade) {
+	if c.Trades != nil {
+		c.Trades <- trade
+	}
+}
+
+func (c ChannelHandler) OnTicker(ticker Ticker) {
+	if c.Tickers != nil {
+		c.Tickers <- ticker
+	}
+}
+
+func (c ChannelHandler) OnFill(fill Fill) {
+	if c.Fills != nil {
+		c.Fills <- fill
+	}
+}
+
+func (c ChannelHandler) OnError(err error) {
+	if c.Errors != nil {
+		c.Errors <- err
+	}
+}
+
+func (c ChannelHandler) OnReconnect(cause error) {
+	if c.Reconnects != nil {
+		c.Reconnects <- cause
+	}
+}
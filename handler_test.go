@@ -0,0 +1,140 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+// acceptChannels accepts n subscriptions in any order, assigning sids by
+// channel.
+func acceptChannels(ctx context.Context, t *testing.T, c *websocket.Conn, sids map[string]int) {
+	for range sids {
+		cmd := readCommand(ctx, t, c)
+		assert.Equal(t, "subscribe", cmd.Command)
+		if !assert.Len(t, cmd.Params.Channels, 1) {
+			return
+		}
+		channel := cmd.Params.Channels[0]
+		writeMessages(ctx, t, c, fmt.Sprintf(
+			`{"id": %d, "type": "subscribed", "msg": {"channel": %q, "sid": %d}}`,
+			cmd.ID, channel, sids[channel],
+		))
+	}
+}
+
+func TestFeedHandle(t *testing.T) {
+	t.Parallel()
+
+	const trade = `{"type": "trade", "sid": 2, "msg": {"market_ticker": "DUH", "yes_price": 41, "no_price": 59, "count": 3, "taker_side": "yes", "ts": 1672628645}}`
+
+	t.Run("Order", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var conns int
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			conns++
+			acceptChannels(ctx, t, c, map[string]int{"orderbook_delta": 1, "trade": 2})
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(testSnapshot, 1, 40),
+				trade,
+				fmt.Sprintf(testDelta, 1, 2, 41),
+			)
+			if conns == 1 {
+				return
+			}
+			<-ctx.Done()
+		})
+		f.Reconnect = &ReconnectPolicy{MinBackoff: time.Millisecond}
+
+		calls := make(chan string, 16)
+		h := FeedHandlerFuncs{
+			Snapshot: func(ev BookEvent) {
+				calls <- fmt.Sprintf("snapshot %s %d", ev.MarketID, ev.Seq)
+			},
+			Delta: func(ev BookEvent) {
+				calls <- fmt.Sprintf("delta %s %d", ev.MarketID, ev.Seq)
+			},
+			Trade: func(trade Trade) {
+				calls <- fmt.Sprintf("trade %s %d", trade.Ticker, trade.Count)
+			},
+			Reconnect: func(cause error) {
+				assert.Error(t, cause)
+				calls <- "reconnect"
+			},
+		}
+		go func() {
+			_ = f.Handle(ctx, h, HandlerSubscription{
+				MarketTickers: []string{"DUH"},
+				Books:         true,
+				Trades:        true,
+			})
+		}()
+
+		var got []string
+		for len(got) < 7 {
+			got = append(got, <-calls)
+		}
+		require.Equal(t, []string{
+			"snapshot DUH 1", "trade DUH 3", "delta DUH 2",
+			"reconnect",
+			"snapshot DUH 1", "trade DUH 3", "delta DUH 2",
+		}, got)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			cmd := readCommand(ctx, t, c)
+			writeMessages(ctx, t, c, fmt.Sprintf(
+				`{"id": %d, "type": "error", "msg": {"code": 8, "msg": "Unknown channel name"}}`, cmd.ID,
+			))
+			<-ctx.Done()
+		})
+
+		errs := make(chan error, 1)
+		err := f.Handle(ctx, ChannelHandler{Errors: errs}, HandlerSubscription{Fills: true})
+		var ferr *FeedError
+		require.ErrorAs(t, err, &ferr)
+		require.Equal(t, 8, ferr.Code)
+		require.Equal(t, err, <-errs)
+
+		require.Error(t, f.Handle(ctx, ChannelHandler{}, HandlerSubscription{}))
+		require.Error(t, f.Handle(ctx, ChannelHandler{}, HandlerSubscription{Books: true}))
+	})
+
+	t.Run("Channels", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			acceptChannels(ctx, t, c, map[string]int{"orderbook_delta": 1, "trade": 2})
+			writeMessages(ctx, t, c, fmt.Sprintf(testSnapshot, 1, 40), trade)
+			<-ctx.Done()
+		})
+
+		var (
+			books  = make(chan BookEvent, 1)
+			trades = make(chan Trade, 1)
+		)
+		go func() {
+			_ = f.Handle(ctx, ChannelHandler{Books: books, Trades: trades}, HandlerSubscription{
+				MarketTickers: []string{"DUH"},
+				Books:         true,
+				Trades:        true,
+			})
+		}()
+		require.Equal(t, SnapshotEvent, (<-books).Type)
+		require.Equal(t, Cents(41), (<-trades).YesPrice)
+	})
+}
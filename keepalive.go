@@ -0,0 +1,141 @@
+package kalshi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// ErrStale is reported when a Feed abandons a connection that stopped
+// responding. See KeepalivePolicy.
+var ErrStale = errors.New("stale connection")
+
+// KeepalivePolicy configures how a Feed detects dead connections. A dead
+// connection fails the Feed with ErrStale, or is replaced if the Feed
+// reconnects.
+//
+// A silently dead connection looks like a quiet market, so the Feed pings
+// the exchange every Interval and expects a pong within Timeout.
+type KeepalivePolicy struct {
+	// Interval defaults to 10s.
+	Interval time.Duration
+	// Timeout defaults to Interval.
+	Timeout time.Duration
+	// StaleAfter, if set, also abandons the connection when no message of
+	// any kind is read for that long, even if pongs arrive. It should only
+	// be set on Feeds that follow active markets.
+	StaleAfter time.Duration
+}
+
+func (p *KeepalivePolicy) interval() time.Duration {
+	if p.Interval <= 0 {
+		return time.Second * 10
+	}
+	return p.Interval
+}
+
+func (p *KeepalivePolicy) timeout() time.Duration {
+	if p.Timeout <= 0 {
+		return p.interval()
+	}
+	return p.Timeout
+}
+
+// LastMessage returns when the last message of any kind was read, or when
+// the current connection was opened if none was.
+func (s *Feed) LastMessage() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastMessage
+}
+
+// StaleSubscriptions returns the subscriptions that have not received a
+// message for at least d. Subscriptions of quiet markets may legitimately
+// be stale.
+func (s *Feed) StaleSubscriptions(d time.Duration) []Subscription {
+	var (
+		now   = time.Now()
+		stale []Subscription
+	)
+	for _, sub := range s.Subscriptions() {
+		if now.Sub(sub.LastMessage) >= d {
+			stale = append(stale, sub)
+		}
+	}
+	return stale
+}
+
+// startKeepalive starts pinging if the Feed is configured to. It runs when
+// the first command is sent, after the Feed is configured.
+func (s *Feed) startKeepalive() {
+	if s.Keepalive != nil {
+		go s.keepalive(*s.Keepalive)
+	}
+}
+
+// keepalive pings the exchange until the reader stops.
+func (s *Feed) keepalive(p KeepalivePolicy) {
+	t := time.NewTicker(p.interval())
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+		}
+
+		s.mu.Lock()
+		var (
+			c           = s.c
+			connected   = s.state == FeedConnected
+			lastMessage = s.lastMessage
+		)
+		s.mu.Unlock()
+		if !connected || c == nil {
+			continue
+		}
+
+		if p.StaleAfter > 0 && time.Since(lastMessage) >= p.StaleAfter {
+			s.abortConn(c, fmt.Errorf("%w: no message for %v", ErrStale, p.StaleAfter))
+			continue
+		}
+
+		s.ping(c, p.timeout())
+	}
+}
+
+// ping aborts c unless it answers a ping within timeout.
+func (s *Feed) ping(c *websocket.Conn, timeout time.Duration) {
+	// Ping closes the connection itself if its context expires, so it is
+	// given none in order to report ErrStale. It returns once the
+	// connection is closed.
+	pong := make(chan error, 1)
+	go func() {
+		pong <- c.Ping(context.Background())
+	}()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case err := <-pong:
+		if err != nil {
+			s.abortConn(c, fmt.Errorf("%w: %v", ErrStale, err))
+		}
+	case <-t.C:
+		s.abortConn(c, fmt.Errorf("%w: no pong within %v", ErrStale, timeout))
+	case <-s.done:
+	}
+}
+
+// abortConn stops reading c if it is still the current connection.
+func (s *Feed) abortConn(c *websocket.Conn, cause error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.c == c && s.abort != nil {
+		s.abort(cause)
+	}
+}
@@ -0,0 +1,131 @@
+package kalshi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestFeedKeepalive(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Pong", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var (
+			mu    sync.Mutex
+			conns int
+		)
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			mu.Lock()
+			conns++
+			n := conns
+			mu.Unlock()
+
+			cmd := readCommand(ctx, t, c)
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(testSubscribed, cmd.ID, n),
+				fmt.Sprintf(testSnapshot, n, 40+n),
+			)
+			if n > 1 {
+				// Pongs are only sent while reading.
+				ctx = c.CloseRead(ctx)
+			}
+			<-ctx.Done()
+		})
+
+		stateErrs := make(chan error, 4)
+		f.Reconnect = &ReconnectPolicy{MinBackoff: time.Millisecond}
+		f.Keepalive = &KeepalivePolicy{
+			Interval: time.Millisecond * 20,
+			Timeout:  time.Millisecond * 100,
+		}
+		f.OnStateChange = func(state FeedState, err error) {
+			if state == FeedReconnecting {
+				stateErrs <- err
+			}
+		}
+
+		events := make(chan BookEvent)
+		go func() {
+			_ = f.BookEvents(ctx, "DUH", SnapshotEvent, events)
+		}()
+		<-events
+
+		// The first connection never answers pings.
+		ev := <-events
+		require.Equal(t, OrderBookBids{{42, 10}}, ev.Book.OrderBook().YesBids)
+		require.ErrorIs(t, <-stateErrs, ErrStale)
+
+		// The second one does.
+		time.Sleep(time.Millisecond * 300)
+		require.Equal(t, FeedConnected, f.State())
+		require.Empty(t, stateErrs)
+	})
+
+	t.Run("StaleAfter", func(t *testing.T) {
+		t.Parallel()
+
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			cmd := readCommand(ctx, t, c)
+			writeMessages(ctx, t, c, fmt.Sprintf(testSubscribed, cmd.ID, 1))
+			<-c.CloseRead(ctx).Done()
+		})
+		f.Keepalive = &KeepalivePolicy{
+			Interval:   time.Millisecond * 10,
+			StaleAfter: time.Millisecond * 50,
+		}
+
+		err := f.Book(context.Background(), "DUH", make(chan *StreamOrderBook))
+		require.ErrorIs(t, err, ErrStale)
+		require.ErrorIs(t, f.Err(), ErrStale)
+		require.Equal(t, FeedClosed, f.State())
+	})
+}
+
+func TestFeedStaleSubscriptions(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		var ticker string
+		for sid := 1; sid <= 2; sid++ {
+			cmd := readCommand(ctx, t, c)
+			ticker = cmd.Params.MarketTicker
+			writeMessages(ctx, t, c, fmt.Sprintf(testSubscribed, cmd.ID, sid))
+		}
+		time.Sleep(time.Millisecond * 50)
+		writeMessages(ctx, t, c, fmt.Sprintf(
+			`{"type": "orderbook_snapshot", "sid": 2, "seq": 1, "msg": {"market_ticker": %q, "yes": [], "no": []}}`,
+			ticker,
+		))
+		<-ctx.Done()
+	})
+
+	books := make(chan *StreamOrderBook, 1)
+	for _, ticker := range []string{"A", "DUH"} {
+		ticker := ticker
+		go func() {
+			err := f.Book(ctx, ticker, books)
+			if !errors.Is(err, context.Canceled) {
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	<-books
+
+	stale := f.StaleSubscriptions(time.Millisecond * 40)
+	require.Len(t, stale, 1)
+	require.Equal(t, 1, stale[0].Sid)
+	require.WithinDuration(t, time.Now(), f.LastMessage(), time.Millisecond*40)
+}
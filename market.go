@@ -297,15 +297,29 @@ func (c *Client) MarketHistory(
 	return &resp, nil
 }
 
+// MarketOrderBookRequest is described here:
+// https://trading-api.readme.io/reference/getmarketorderbook.
+type MarketOrderBookRequest struct {
+	// Depth limits the number of price levels returned for each side. Top of
+	// book pollers should request a small depth as the response is cheaper
+	// to produce and parse. Zero returns the full book.
+	Depth int `url:"depth,omitempty"`
+}
+
 // MarketOrderBook is described here:
 // https://trading-api.readme.io/reference/getmarketorderbook.
-func (c *Client) MarketOrderBook(ctx context.Context, ticker string) (*OrderBook, error) {
+func (c *Client) MarketOrderBook(ctx context.Context, ticker string, req MarketOrderBookRequest) (*OrderBook, error) {
+	if req.Depth < 0 || req.Depth > 100 {
+		return nil, fmt.Errorf("depth %v out of range [0, 100]", req.Depth)
+	}
+
 	var resp struct {
 		OrderBook OrderBook `json:"orderbook"`
 	}
 	err := c.request(ctx, request{
 		Method:       "GET",
-		Endpoint:     fmt.Sprintf("markets/%s/orderbook/?depth=100", ticker),
+		Endpoint:     fmt.Sprintf("markets/%s/orderbook", ticker),
+		QueryParams:  req,
 		JSONResponse: &resp,
 	})
 	if err != nil {
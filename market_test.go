@@ -2,6 +2,9 @@ package kalshi
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -103,3 +106,30 @@ func TestSeries(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, resp)
 }
+
+func TestMarketOrderBookDepth(t *testing.T) {
+	t.Parallel()
+
+	var gotURL *url.URL
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL
+		_, _ = w.Write([]byte(`{"orderbook": {"yes": [[40, 3]], "no": null}}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := New(srv.URL + "/")
+
+	book, err := client.MarketOrderBook(ctx, "TEST", MarketOrderBookRequest{Depth: 3})
+	require.NoError(t, err)
+	require.Equal(t, "/markets/TEST/orderbook", gotURL.Path)
+	require.Equal(t, "3", gotURL.Query().Get("depth"))
+	require.Equal(t, OrderBookBids{{40, 3}}, book.YesBids)
+
+	_, err = client.MarketOrderBook(ctx, "TEST", MarketOrderBookRequest{})
+	require.NoError(t, err)
+	require.Empty(t, gotURL.RawQuery)
+
+	_, err = client.MarketOrderBook(ctx, "TEST", MarketOrderBookRequest{Depth: 101})
+	require.Error(t, err)
+}
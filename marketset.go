@@ -0,0 +1,276 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// followRetryInterval spaces out retries of the REST requests and updates
+// that add listed markets to a stream.
+var followRetryInterval = time.Second
+
+// MarketSet selects every market of an event or of a series.
+type MarketSet struct {
+	// Exactly one of EventTicker and SeriesTicker must be set.
+	EventTicker  string
+	SeriesTicker string
+	// Status, if set, filters the markets that are subscribed when the set
+	// is resolved, such as "open". Markets listed later are added
+	// regardless of their status.
+	Status string
+	// OnLookupError, if set, is called when a newly listed market could not
+	// be checked against the set. The lookup is retried until it succeeds.
+	OnLookupError func(marketTicker string, err error)
+}
+
+// mayContain reports whether a market ticker can belong to set. Market
+// tickers start with the ticker of their event, which starts with the
+// ticker of its series, so markets of other series and events are ruled
+// out without a lookup.
+func (set MarketSet) mayContain(marketTicker string) bool {
+	prefix := set.EventTicker
+	if prefix == "" {
+		prefix = set.SeriesTicker
+	}
+	return strings.HasPrefix(strings.ToUpper(marketTicker), strings.ToUpper(prefix)+"-")
+}
+
+func (set MarketSet) String() string {
+	if set.EventTicker != "" {
+		return "event " + set.EventTicker
+	}
+	return "series " + set.SeriesTicker
+}
+
+// resolveMarkets lists the tickers of the markets in set.
+func (c *Client) resolveMarkets(ctx context.Context, set MarketSet) ([]string, error) {
+	req := MarketsRequest{
+		EventTicker:  set.EventTicker,
+		SeriesTicker: set.SeriesTicker,
+		Status:       set.Status,
+	}
+	var tickers []string
+	for {
+		resp, err := c.Markets(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range resp.Markets {
+			tickers = append(tickers, m.Ticker)
+		}
+		if resp.Cursor == "" || len(resp.Markets) == 0 {
+			return tickers, nil
+		}
+		req.Cursor = resp.Cursor
+	}
+}
+
+// inMarketSet reports whether the market marketTicker belongs to set. series
+// caches the series of events.
+func (c *Client) inMarketSet(ctx context.Context, set MarketSet, marketTicker string, series map[string]string) (bool, error) {
+	if !set.mayContain(marketTicker) {
+		return false, nil
+	}
+	m, err := c.Market(ctx, marketTicker)
+	if err != nil {
+		return false, err
+	}
+	if set.EventTicker != "" {
+		return m.EventTicker == set.EventTicker, nil
+	}
+	seriesTicker, ok := series[m.EventTicker]
+	if !ok {
+		ev, err := c.Event(ctx, m.EventTicker)
+		if err != nil {
+			return false, err
+		}
+		seriesTicker = ev.Event.SeriesTicker
+		series[m.EventTicker] = seriesTicker
+	}
+	return seriesTicker == set.SeriesTicker, nil
+}
+
+// FollowBooks streams the order books of every market in set like Books.
+//
+// Markets listed after the set is resolved are added to the stream as the
+// market lifecycle channel announces them, and the set is resolved again
+// after every reconnect to catch markets listed in the meantime. Only listed
+// markets whose ticker starts with the event or series ticker of set are
+// looked up over REST. Lookups wait for the read rate limit of the Client,
+// and failed lookups are reported to set.OnLookupError and retried. The
+// Feed must have been opened by a Client.
+func (s *Feed) FollowBooks(ctx context.Context, set MarketSet, feed chan<- *StreamOrderBook) error {
+	return s.follow(ctx, set, func(ctx context.Context, marketTickers []string, ref *streamRef) error {
+		return s.books(ctx, marketTickers, ref, feed)
+	})
+}
+
+// follow runs stream on the markets of set, and adds markets to it as they
+// are listed.
+func (s *Feed) follow(ctx context.Context, set MarketSet, stream func(ctx context.Context, marketTickers []string, ref *streamRef) error) error {
+	if (set.EventTicker == "") == (set.SeriesTicker == "") {
+		return fmt.Errorf("market set needs one of an event or series ticker")
+	}
+	if s.client == nil {
+		return fmt.Errorf("resolve %v: feed has no client", set)
+	}
+	tickers, err := s.client.resolveMarkets(ctx, set)
+	if err != nil {
+		return fmt.Errorf("resolve %v: %w", set, err)
+	}
+	if len(tickers) == 0 {
+		return fmt.Errorf("no markets in %v", set)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Lookups happen in bursts as markets are listed, and must not be lost
+	// to the rate limit.
+	lookupCtx := waitRateLimit(ctx)
+
+	// listed and resolve are filled from the reader goroutine.
+	var (
+		mu      sync.Mutex
+		listed  []string
+		resolve bool
+		wake    = make(chan struct{}, 1)
+	)
+	signal := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+	stop := s.listenState(func(state FeedState, _ error) {
+		if state == FeedConnected {
+			mu.Lock()
+			resolve = true
+			mu.Unlock()
+			signal()
+		}
+	})
+	defer stop()
+
+	ref := &streamRef{}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- stream(ctx, tickers, ref)
+	}()
+	go func() {
+		errs <- streamFunc(ctx, s,
+			marketParams("market_lifecycle_v2", nil), false,
+			"market_lifecycle_v2", identity[MarketLifecycleEvent],
+			func(ev MarketLifecycleEvent) {
+				if ev.EventType != MarketCreated || !set.mayContain(ev.MarketTicker) {
+					return
+				}
+				mu.Lock()
+				listed = append(listed, ev.MarketTicker)
+				mu.Unlock()
+				signal()
+			},
+		)
+	}()
+
+	var (
+		series = make(map[string]string)
+		// checked caches the markets that were looked up.
+		checked = make(map[string]bool)
+		// unchecked holds listed markets that may belong to set.
+		unchecked    = make(map[string]bool)
+		resolveAgain bool
+		// add holds markets of set that may be missing from the stream.
+		add   = make(map[string]bool)
+		retry <-chan time.Time
+	)
+	for {
+		select {
+		case err := <-errs:
+			cancel()
+			<-errs
+			return err
+		case <-wake:
+		case <-retry:
+			retry = nil
+		}
+
+		mu.Lock()
+		for _, ticker := range listed {
+			if !checked[ticker] {
+				unchecked[ticker] = true
+			}
+		}
+		listed = nil
+		resolveAgain = resolveAgain || resolve
+		resolve = false
+		mu.Unlock()
+
+		if resolveAgain {
+			tickers, err := s.client.resolveMarkets(lookupCtx, set)
+			if err == nil {
+				resolveAgain = false
+				for _, ticker := range tickers {
+					add[ticker] = true
+				}
+			}
+		}
+		for ticker := range unchecked {
+			ok, err := s.client.inMarketSet(lookupCtx, set, ticker, series)
+			if err != nil {
+				if ctx.Err() == nil && set.OnLookupError != nil {
+					set.OnLookupError(ticker, err)
+				}
+				continue
+			}
+			delete(unchecked, ticker)
+			checked[ticker] = true
+			if ok {
+				add[ticker] = true
+			}
+		}
+		_ = s.addMarkets(ctx, ref, add)
+
+		if retry == nil && (resolveAgain || len(unchecked) > 0 || len(add) > 0) {
+			retry = time.After(followRetryInterval)
+		}
+	}
+}
+
+// addMarkets adds the markets in add that the stream of ref does not follow
+// yet, and removes them from add once the exchange acknowledges them.
+func (s *Feed) addMarkets(ctx context.Context, ref *streamRef, add map[string]bool) error {
+	s.mu.Lock()
+	var sid int
+	if ref.sub != nil {
+		sid = ref.sub.sid
+		for _, ticker := range ref.sub.params.marketTickers() {
+			delete(add, ticker)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(add) == 0 {
+		return nil
+	}
+	if sid == 0 {
+		return fmt.Errorf("stream is not subscribed")
+	}
+
+	tickers := make([]string, 0, len(add))
+	for ticker := range add {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+	err := s.UpdateSubscription(ctx, sid, AddMarkets, tickers)
+	if err != nil {
+		return err
+	}
+	for _, ticker := range tickers {
+		delete(add, ticker)
+	}
+	return nil
+}
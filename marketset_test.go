@@ -0,0 +1,213 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+	"nhooyr.io/websocket"
+)
+
+func TestFeedFollowBooks(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		snapshot = `{"type": "orderbook_snapshot", "sid": 1, "seq": %d, "msg": {"market_ticker": %q, "yes": [[40, 10]], "no": []}}`
+		created  = `{"type": "market_lifecycle_v2", "sid": 2, "seq": %d, "msg": {"market_ticker": %q, "event_type": "created"}}`
+		ok       = `{"id": %d, "type": "ok", "sid": 1, "seq": %d, "msg": {"market_tickers": [%s]}}`
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/markets":
+			assert.Equal(t, "SER", r.URL.Query().Get("series_ticker"))
+			if r.URL.Query().Get("cursor") == "" {
+				_, _ = w.Write([]byte(`{"markets": [{"ticker": "SER-A-1"}], "cursor": "next"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"markets": [{"ticker": "SER-B-1"}], "cursor": ""}`))
+		case "/markets/SER-C-1":
+			_, _ = w.Write([]byte(`{"market": {"ticker": "SER-C-1", "event_ticker": "SER-C"}}`))
+		case "/events/SER-C":
+			_, _ = w.Write([]byte(`{"event": {"event_ticker": "SER-C", "series_ticker": "SER"}}`))
+		default:
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		sids := map[string]int{"orderbook_delta": 1, "market_lifecycle_v2": 2}
+		for i := 0; i < 2; i++ {
+			cmd := readCommand(ctx, t, c)
+			channel := cmd.Params.Channels[0]
+			writeMessages(ctx, t, c, fmt.Sprintf(
+				`{"id": %d, "type": "subscribed", "msg": {"channel": %q, "sid": %d}}`,
+				cmd.ID, channel, sids[channel],
+			))
+			if channel == "orderbook_delta" {
+				assert.Equal(t, []string{"SER-A-1", "SER-B-1"}, cmd.Params.MarketTickers)
+				writeMessages(ctx, t, c,
+					fmt.Sprintf(snapshot, 1, "SER-A-1"),
+					fmt.Sprintf(snapshot, 2, "SER-B-1"),
+				)
+			}
+		}
+
+		// Only the market listed in the series is looked up and added.
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(created, 1, "OTHER-A-1"),
+			fmt.Sprintf(created, 2, "SER-C-1"),
+		)
+		cmd := readCommand(ctx, t, c)
+		assert.Equal(t, "update_subscription", cmd.Command)
+		assert.Equal(t, commandParams{
+			Sids:          []int{1},
+			MarketTickers: []string{"SER-C-1"},
+			Action:        "add_markets",
+		}, cmd.Params)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(ok, cmd.ID, 3, `"SER-A-1", "SER-B-1", "SER-C-1"`),
+			fmt.Sprintf(snapshot, 4, "SER-C-1"),
+		)
+		<-ctx.Done()
+	})
+	f.client = New(srv.URL + "/")
+
+	books := make(chan *StreamOrderBook)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- f.FollowBooks(ctx, MarketSet{SeriesTicker: "SER"}, books)
+	}()
+
+	var got []string
+	for len(got) < 3 {
+		select {
+		case book := <-books:
+			got = append(got, book.MarketID)
+		case err := <-errs:
+			t.Fatalf("follow: %v", err)
+		}
+	}
+	require.Equal(t, []string{"SER-A-1", "SER-B-1", "SER-C-1"}, got)
+
+	subs := f.Subscriptions()
+	require.Len(t, subs, 2)
+	require.Equal(t, []string{"SER-A-1", "SER-B-1", "SER-C-1"}, subs[0].MarketTickers)
+
+	cancel()
+	require.ErrorIs(t, <-errs, context.Canceled)
+
+	require.Error(t, f.FollowBooks(context.Background(), MarketSet{}, books))
+	require.Error(t, f.FollowBooks(context.Background(), MarketSet{EventTicker: "A", SeriesTicker: "B"}, books))
+}
+
+func TestFeedFollowBooksLookups(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const listed = 12
+
+	var (
+		mu       sync.Mutex
+		failed   bool
+		reported []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		// The first lookup of SER-C-3 fails.
+		fail := r.URL.Path == "/markets/SER-C-3" && !failed
+		failed = failed || fail
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/markets":
+			_, _ = w.Write([]byte(`{"markets": [{"ticker": "SER-A-1"}], "cursor": ""}`))
+		case fail:
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasPrefix(r.URL.Path, "/markets/SER-C-"):
+			fmt.Fprintf(w, `{"market": {"ticker": %q, "event_ticker": "SER-C"}}`, strings.TrimPrefix(r.URL.Path, "/markets/"))
+		case r.URL.Path == "/events/SER-C":
+			_, _ = w.Write([]byte(`{"event": {"event_ticker": "SER-C", "series_ticker": "SER"}}`))
+		default:
+			t.Errorf("unexpected request %v", r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		sids := map[string]int{"orderbook_delta": 1, "market_lifecycle_v2": 2}
+		for i := 0; i < 2; i++ {
+			cmd := readCommand(ctx, t, c)
+			channel := cmd.Params.Channels[0]
+			writeMessages(ctx, t, c, fmt.Sprintf(
+				`{"id": %d, "type": "subscribed", "msg": {"channel": %q, "sid": %d}}`,
+				cmd.ID, channel, sids[channel],
+			))
+		}
+
+		// Markets of other series are never looked up, and more markets
+		// are listed at once than the rate limit allows requests.
+		msgs := []string{
+			`{"type": "market_lifecycle_v2", "sid": 2, "seq": 1, "msg": {"market_ticker": "OTHER-A-1", "event_type": "created"}}`,
+		}
+		for n := 1; n <= listed; n++ {
+			msgs = append(msgs, fmt.Sprintf(
+				`{"type": "market_lifecycle_v2", "sid": 2, "seq": %d, "msg": {"market_ticker": "SER-C-%d", "event_type": "created"}}`,
+				n+1, n,
+			))
+		}
+		writeMessages(ctx, t, c, msgs...)
+
+		tickers := []string{"SER-A-1"}
+		for seq := 1; len(tickers) < listed+1; seq++ {
+			cmd := readCommand(ctx, t, c)
+			if ctx.Err() != nil {
+				return
+			}
+			assert.Equal(t, "update_subscription", cmd.Command)
+			tickers = append(tickers, cmd.Params.MarketTickers...)
+			writeMessages(ctx, t, c, fmt.Sprintf(
+				`{"id": %d, "type": "ok", "sid": 1, "seq": %d, "msg": {"market_tickers": ["%s"]}}`,
+				cmd.ID, seq, strings.Join(tickers, `", "`),
+			))
+		}
+		<-ctx.Done()
+	})
+	f.client = New(srv.URL + "/")
+	f.client.ReadRateLimit = rate.NewLimiter(rate.Every(time.Millisecond*5), 1)
+
+	set := MarketSet{
+		SeriesTicker: "SER",
+		OnLookupError: func(marketTicker string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			reported = append(reported, marketTicker)
+		},
+	}
+	go func() {
+		_ = f.FollowBooks(ctx, set, make(chan *StreamOrderBook))
+	}()
+
+	require.Eventually(t, func() bool {
+		subs := f.Subscriptions()
+		return len(subs) == 2 && len(subs[0].MarketTickers) == listed+1
+	}, time.Second*10, time.Millisecond*10)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"SER-C-3"}, reported)
+}
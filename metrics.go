@@ -0,0 +1,171 @@
+package kalshi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WritePrometheus writes st in the Prometheus text exposition format, so that
+// Feeds can be scraped without a dependency on the Prometheus client. Metric
+// names start with "kalshi_feed_", and subscription metrics are labeled by
+// sid and channel.
+func (st FeedStats) WritePrometheus(w io.Writer) error {
+	pw := &promWriter{w: bufio.NewWriter(w)}
+
+	pw.family("kalshi_feed_state", "gauge", "Connection state of the feed.")
+	for _, state := range []FeedState{FeedConnected, FeedReconnecting, FeedClosed} {
+		var v float64
+		if st.State == state {
+			v = 1
+		}
+		pw.sample("kalshi_feed_state", v, "state", state.String())
+	}
+
+	pw.family("kalshi_feed_messages_total", "counter", "Messages read by type.")
+	for _, typ := range sortedKeys(st.Messages) {
+		pw.sample("kalshi_feed_messages_total", float64(st.Messages[typ]), "type", typ)
+	}
+	pw.counter("kalshi_feed_bytes_total", "Bytes of messages read.", st.Bytes)
+	pw.counter("kalshi_feed_reconnects_total", "Connections replaced after failures.", st.Reconnects)
+	pw.counter("kalshi_feed_sequence_gaps_total", "Sequence gaps detected.", st.SequenceGaps)
+
+	pw.family("kalshi_feed_delivery_queued", "gauge", "Books and events waiting for consumers.")
+	pw.sample("kalshi_feed_delivery_queued", float64(st.Delivery.Queued))
+	pw.family("kalshi_feed_delivery_max_queued", "gauge", "Highest number of books and events waiting for consumers.")
+	pw.sample("kalshi_feed_delivery_max_queued", float64(st.Delivery.MaxQueued))
+	pw.counter("kalshi_feed_delivery_delivered_total", "Books and events delivered to consumers.", st.Delivery.Delivered)
+	pw.counter("kalshi_feed_delivery_dropped_total", "Books and events dropped by DropOldest.", st.Delivery.Dropped)
+	pw.counter("kalshi_feed_delivery_conflated_total", "Books and events replaced by Conflate.", st.Delivery.Conflated)
+
+	if len(st.Subscriptions) == 0 {
+		return pw.flush()
+	}
+
+	pw.family("kalshi_feed_subscription_messages_total", "counter", "Messages of a subscription by type.")
+	for _, sub := range st.Subscriptions {
+		for _, typ := range sortedKeys(sub.Messages) {
+			pw.sample("kalshi_feed_subscription_messages_total", float64(sub.Messages[typ]),
+				"sid", strconv.Itoa(sub.Sid), "channel", sub.Channel, "type", typ)
+		}
+	}
+	pw.family("kalshi_feed_subscription_bytes_total", "counter", "Bytes of messages of a subscription.")
+	for _, sub := range st.Subscriptions {
+		pw.sample("kalshi_feed_subscription_bytes_total", float64(sub.Bytes), sub.labels()...)
+	}
+	pw.family("kalshi_feed_subscription_sequence_gaps_total", "counter", "Sequence gaps of a subscription.")
+	for _, sub := range st.Subscriptions {
+		pw.sample("kalshi_feed_subscription_sequence_gaps_total", float64(sub.SequenceGaps), sub.labels()...)
+	}
+	pw.family("kalshi_feed_subscription_last_message_timestamp_seconds", "gauge", "Time of the last message of a subscription.")
+	for _, sub := range st.Subscriptions {
+		pw.sample("kalshi_feed_subscription_last_message_timestamp_seconds",
+			float64(sub.LastMessage.UnixNano())/1e9, sub.labels()...)
+	}
+	pw.family("kalshi_feed_subscription_decode_seconds", "histogram", "Time spent decoding messages of a subscription.")
+	for _, sub := range st.Subscriptions {
+		pw.histogram("kalshi_feed_subscription_decode_seconds", sub.DecodeTime, sub.labels()...)
+	}
+	pw.family("kalshi_feed_subscription_latency_seconds", "histogram", "Time from exchange timestamps to decoding.")
+	for _, sub := range st.Subscriptions {
+		pw.histogram("kalshi_feed_subscription_latency_seconds", sub.Latency, sub.labels()...)
+	}
+	return pw.flush()
+}
+
+func (sub SubscriptionStats) labels() []string {
+	return []string{"sid", strconv.Itoa(sub.Sid), "channel", sub.Channel}
+}
+
+// PrometheusHandler serves the result of stats in the Prometheus text
+// exposition format. Pass the Stats method of a Feed.
+func PrometheusHandler(stats func() FeedStats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_ = stats().WritePrometheus(w)
+	})
+}
+
+// promWriter writes the Prometheus text format and keeps the first error.
+type promWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (pw *promWriter) printf(format string, args ...any) {
+	if pw.err != nil {
+		return
+	}
+	_, pw.err = fmt.Fprintf(pw.w, format, args...)
+}
+
+func (pw *promWriter) family(name, typ, help string) {
+	pw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (pw *promWriter) counter(name, help string, v uint64) {
+	pw.family(name, "counter", help)
+	pw.sample(name, float64(v))
+}
+
+// sample writes a sample with labels given as name and value pairs.
+func (pw *promWriter) sample(name string, v float64, labels ...string) {
+	pw.printf("%s%s %s\n", name, formatLabels(labels), strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (pw *promWriter) histogram(name string, h Histogram, labels ...string) {
+	var cumulative uint64
+	for i, bound := range h.Bounds {
+		cumulative += h.Counts[i]
+		pw.sample(name+"_bucket", float64(cumulative), append(labels, "le", formatSeconds(bound))...)
+	}
+	pw.sample(name+"_bucket", float64(h.Count), append(labels, "le", "+Inf")...)
+	pw.sample(name+"_sum", h.Sum.Seconds(), labels...)
+	pw.sample(name+"_count", float64(h.Count), labels...)
+}
+
+func (pw *promWriter) flush() error {
+	if pw.err != nil {
+		return pw.err
+	}
+	return pw.w.Flush()
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(promLabelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
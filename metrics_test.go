@@ -0,0 +1,71 @@
+package kalshi
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedStatsWritePrometheus(t *testing.T) {
+	t.Parallel()
+
+	st := FeedStats{
+		State:      FeedConnected,
+		Messages:   map[string]uint64{"trade": 3, "orderbook_delta": 5},
+		Bytes:      100,
+		Reconnects: 1,
+		Subscriptions: []SubscriptionStats{{
+			Subscription: Subscription{Sid: 7, Channel: "trade", LastMessage: time.Unix(1672628645, 0)},
+			Messages:     map[string]uint64{"trade": 3},
+			Bytes:        60,
+			Latency: Histogram{
+				Bounds: []time.Duration{time.Millisecond * 500, time.Second},
+				Counts: []uint64{1, 1, 1},
+				Count:  3,
+				Sum:    time.Millisecond * 3500,
+			},
+			DecodeTime: Histogram{Bounds: decodeBounds, Counts: make([]uint64, len(decodeBounds)+1)},
+		}},
+	}
+
+	srv := httptest.NewServer(PrometheusHandler(func() FeedStats {
+		return st
+	}))
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	lines := strings.Split(string(body), "\n")
+
+	for _, want := range []string{
+		"# TYPE kalshi_feed_state gauge",
+		`kalshi_feed_state{state="connected"} 1`,
+		`kalshi_feed_state{state="reconnecting"} 0`,
+		"# TYPE kalshi_feed_messages_total counter",
+		`kalshi_feed_messages_total{type="orderbook_delta"} 5`,
+		`kalshi_feed_messages_total{type="trade"} 3`,
+		"kalshi_feed_bytes_total 100",
+		"kalshi_feed_reconnects_total 1",
+		"kalshi_feed_delivery_queued 0",
+		`kalshi_feed_subscription_messages_total{sid="7",channel="trade",type="trade"} 3`,
+		`kalshi_feed_subscription_bytes_total{sid="7",channel="trade"} 60`,
+		`kalshi_feed_subscription_last_message_timestamp_seconds{sid="7",channel="trade"} 1.672628645e+09`,
+		"# TYPE kalshi_feed_subscription_latency_seconds histogram",
+		`kalshi_feed_subscription_latency_seconds_bucket{sid="7",channel="trade",le="0.5"} 1`,
+		`kalshi_feed_subscription_latency_seconds_bucket{sid="7",channel="trade",le="1"} 2`,
+		`kalshi_feed_subscription_latency_seconds_bucket{sid="7",channel="trade",le="+Inf"} 3`,
+		`kalshi_feed_subscription_latency_seconds_sum{sid="7",channel="trade"} 3.5`,
+		`kalshi_feed_subscription_latency_seconds_count{sid="7",channel="trade"} 3`,
+		`kalshi_feed_subscription_decode_seconds_bucket{sid="7",channel="trade",le="1e-06"} 0`,
+	} {
+		require.Contains(t, lines, want)
+	}
+	require.Equal(t, `{a="x\"y\\z\n"}`, formatLabels([]string{"a", "x\"y\\z\n"}))
+}
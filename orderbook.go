@@ -3,6 +3,9 @@ package kalshi
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
 )
 
 type OrderBookBids []OrderBookBid
@@ -30,6 +33,48 @@ func (o *OrderBookBid) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+type OrderBookDollarBids []OrderBookDollarBid
+
+// OrderBookDollarBid is an OrderBookBid with a dollar denominated price.
+// Dollar prices may fall between whole cents.
+type OrderBookDollarBid struct {
+	Price    float64
+	Quantity int
+}
+
+// Cents returns the price rounded to the nearest cent.
+func (o OrderBookDollarBid) Cents() Cents {
+	return Cents(math.Round(o.Price * 100))
+}
+
+func (o OrderBookDollarBid) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("[%q,%d]", strconv.FormatFloat(o.Price, 'f', 4, 64), o.Quantity)), nil
+}
+
+func (o *OrderBookDollarBid) UnmarshalJSON(b []byte) error {
+	// The exchange sends prices as strings to avoid floating point
+	// ambiguity. json.Number accepts both strings and plain numbers.
+	var raw [2]json.Number
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+	price, err := raw[0].Float64()
+	if err != nil {
+		return fmt.Errorf("price: %w", err)
+	}
+	quantity, err := raw[1].Int64()
+	if err != nil {
+		return fmt.Errorf("quantity: %w", err)
+	}
+
+	*o = OrderBookDollarBid{
+		Price:    price,
+		Quantity: int(quantity),
+	}
+	return nil
+}
+
 // YesLiquidity returns the total sum required to buy all available
 // Yes contracts on the market.
 func (b OrderBook) YesLiquidity() Cents {
@@ -112,36 +157,138 @@ func (b OrderBook) BestNoOffer(quantity int) (Cents, bool) {
 // bestPrice returns the best average asking price that a slice of bids
 // provides to the opposite side of the market.
 func (b OrderBookBids) bestPrice(wantQuantity int) (Cents, bool) {
-	var (
-		foundQuantity int
-		weightedCum   int
-		price         Cents
-	)
+	fill := b.take(Buy, wantQuantity, 0)
+	if !fill.Complete() {
+		return -1, false
+	}
+	return fill.AveragePrice, true
+}
 
-	// The best priced options are at the end of the book.
-	// Range in reverse
-	for i := len(b) - 1; i >= 0; i-- {
-		line := b[i]
-		price = Cents(100) - line.Price
-		quantity := line.Quantity
+// YesSellProceeds returns the total proceeds of selling quantity Yes
+// contracts into the Yes bids. It returns false if the book cannot absorb
+// the entire quantity.
+func (b OrderBook) YesSellProceeds(quantity int) (Cents, bool) {
+	fill := b.YesBids.take(Sell, quantity, 0)
+	return fill.Total, fill.Complete()
+}
+
+// NoSellProceeds returns the total proceeds of selling quantity No
+// contracts into the No bids. It returns false if the book cannot absorb
+// the entire quantity.
+func (b OrderBook) NoSellProceeds(quantity int) (Cents, bool) {
+	fill := b.NoBids.take(Sell, quantity, 0)
+	return fill.Total, fill.Complete()
+}
+
+// FillLevel is the quantity taken from a single price level by a
+// SimulatedFill.
+type FillLevel struct {
+	Price    Cents
+	Quantity int
+}
 
-		// If we're above wantQuatity, we reduce the amount we're going to
-		// take.
-		if rem := (quantity + foundQuantity) - wantQuantity; rem > 0 {
-			quantity -= rem
+// SimulatedFill describes how an order would execute against the book
+// without accounting for fees or other market participants.
+type SimulatedFill struct {
+	Requested int
+	Filled    int
+	// AveragePrice is rounded against the taker: up when buying and down
+	// when selling.
+	AveragePrice Cents
+	// WorstPrice is the price of the last level touched.
+	WorstPrice Cents
+	// Total is the cost of a buy or the proceeds of a sell.
+	Total Cents
+	// Levels is ordered from the best price to the worst.
+	Levels []FillLevel
+}
+
+// Complete reports whether the entire requested quantity was filled.
+func (f SimulatedFill) Complete() bool {
+	return f.Requested > 0 && f.Filled == f.Requested
+}
+
+// SimulateFill walks the book to estimate the execution of a taker order.
+//
+// Buys take the bids of the opposite side at their complementary price,
+// while sells are matched against the bids on the same side. When limit is
+// non-zero, levels priced worse than limit are not taken, which may result in
+// a partial fill. Levels with non-positive quantities or prices outside of
+// (0, 100) are ignored.
+func (b OrderBook) SimulateFill(action OrderAction, side Side, quantity int, limit Cents) (SimulatedFill, error) {
+	var bids OrderBookBids
+	switch {
+	case action == Buy && side == Yes, action == Sell && side == No:
+		bids = b.NoBids
+	case action == Buy && side == No, action == Sell && side == Yes:
+		bids = b.YesBids
+	case side != Yes && side != No:
+		return SimulatedFill{}, fmt.Errorf("unknown side: %v", side)
+	default:
+		return SimulatedFill{}, fmt.Errorf("unknown action: %v", action)
+	}
+	return bids.take(action, quantity, limit), nil
+}
+
+// take simulates a taker consuming up to wantQuantity contracts from a slice
+// of bids. Buys pay the complementary price of each bid and sells receive the
+// bid price. In both cases the highest bids are taken first.
+func (b OrderBookBids) take(action OrderAction, wantQuantity int, limit Cents) SimulatedFill {
+	fill := SimulatedFill{Requested: wantQuantity}
+	if wantQuantity <= 0 {
+		return fill
+	}
+
+	levels := make(OrderBookBids, 0, len(b))
+	for _, v := range b {
+		if v.Quantity <= 0 || v.Price <= 0 || v.Price >= 100 {
+			continue
+		}
+		levels = append(levels, v)
+	}
+	sort.SliceStable(levels, func(i, j int) bool {
+		return levels[i].Price > levels[j].Price
+	})
+
+	for _, line := range levels {
+		price := line.Price
+		if action == Buy {
+			price = 100 - line.Price
+		}
+		if limit != 0 {
+			if action == Buy && price > limit {
+				break
+			}
+			if action != Buy && price < limit {
+				break
+			}
 		}
 
-		foundQuantity += quantity
-		weightedCum += quantity * int(price)
+		quantity := line.Quantity
+		if rem := wantQuantity - fill.Filled; quantity > rem {
+			quantity = rem
+		}
+
+		fill.Filled += quantity
+		fill.Total += Cents(quantity) * price
+		fill.WorstPrice = price
+		fill.Levels = append(fill.Levels, FillLevel{Price: price, Quantity: quantity})
+
+		if fill.Filled == wantQuantity {
+			break
+		}
+	}
 
-		if foundQuantity == wantQuantity {
-			// We round up to be conservative.
-			return Cents(conservativeRound(float64(weightedCum) / float64(wantQuantity))), true
-		} else if foundQuantity > wantQuantity {
-			panic(fmt.Sprintf("%+v %+v", foundQuantity, wantQuantity))
+	if fill.Filled > 0 {
+		avg := float64(fill.Total) / float64(fill.Filled)
+		// We round against the taker to be conservative.
+		if action == Buy {
+			fill.AveragePrice = Cents(conservativeRound(avg))
+		} else {
+			fill.AveragePrice = Cents(avg)
 		}
 	}
-	return -1, false
+	return fill
 }
 
 func conservativeRound(a float64) int {
@@ -165,4 +312,183 @@ func conservativeRound(a float64) int {
 type OrderBook struct {
 	YesBids OrderBookBids `json:"yes"`
 	NoBids  OrderBookBids `json:"no"`
+
+	// YesDollars and NoDollars mirror YesBids and NoBids with dollar
+	// denominated prices. They are only populated by the REST API.
+	YesDollars OrderBookDollarBids `json:"yes_dollars,omitempty"`
+	NoDollars  OrderBookDollarBids `json:"no_dollars,omitempty"`
+}
+
+// bidQuantities returns the total quantity at each price of a slice of bids.
+func (b OrderBookBids) bidQuantities() map[Cents]int {
+	m := make(map[Cents]int, len(b))
+	for _, v := range b {
+		m[v.Price] += v.Quantity
+	}
+	return m
+}
+
+func diffOrderBookBids(side Side, from, to OrderBookBids) []BookDelta {
+	var (
+		fromQuantities = from.bidQuantities()
+		toQuantities   = to.bidQuantities()
+		deltas         []BookDelta
+	)
+	for price, q := range toQuantities {
+		if d := q - fromQuantities[price]; d != 0 {
+			deltas = append(deltas, BookDelta{Side: side, Price: price, Delta: d})
+		}
+	}
+	for price, q := range fromQuantities {
+		if _, ok := toQuantities[price]; !ok && q != 0 {
+			deltas = append(deltas, BookDelta{Side: side, Price: price, Delta: -q})
+		}
+	}
+	sort.Slice(deltas, func(i, j int) bool {
+		return deltas[i].Price < deltas[j].Price
+	})
+	return deltas
+}
+
+// DiffOrderBooks returns the deltas that transform from into to, in the
+// format sent by the streaming feed. Yes deltas come first, each side in
+// ascending price order. Dollar denominated prices are ignored.
+//
+// This allows books polled via MarketOrderBook to be consumed by the same
+// code as streamed books.
+func DiffOrderBooks(from, to OrderBook) []BookDelta {
+	return append(
+		diffOrderBookBids(Yes, from.YesBids, to.YesBids),
+		diffOrderBookBids(No, from.NoBids, to.NoBids)...,
+	)
+}
+
+// ApplyDeltas returns a copy of the book with deltas applied. Levels that
+// reach zero are removed and each side is sorted in ascending price order.
+// Dollar denominated prices are not carried over to the new book.
+func (b OrderBook) ApplyDeltas(deltas []BookDelta) (OrderBook, error) {
+	var (
+		yes = b.YesBids.bidQuantities()
+		no  = b.NoBids.bidQuantities()
+	)
+	for i, d := range deltas {
+		var dir map[Cents]int
+		switch d.Side {
+		case Yes:
+			dir = yes
+		case No:
+			dir = no
+		default:
+			return OrderBook{}, fmt.Errorf("delta %v: unknown side: %v", i, d.Side)
+		}
+		dir[d.Price] += d.Delta
+		if dir[d.Price] < 0 {
+			return OrderBook{}, fmt.Errorf("delta %v: %v %d below zero", i, d.Side, d.Price)
+		}
+	}
+
+	bids := func(m map[Cents]int) OrderBookBids {
+		var bids OrderBookBids
+		for price, q := range m {
+			if q != 0 {
+				bids = append(bids, OrderBookBid{Price: price, Quantity: q})
+			}
+		}
+		sort.Slice(bids, func(i, j int) bool {
+			return bids[i].Price < bids[j].Price
+		})
+		return bids
+	}
+	return OrderBook{
+		YesBids: bids(yes),
+		NoBids:  bids(no),
+	}, nil
+}
+
+// IntegrityProblem is the kind of an IntegrityError.
+type IntegrityProblem string
+
+const (
+	// CrossedBook means the best Yes and No bids sum to more than 100, so
+	// they should have matched.
+	CrossedBook IntegrityProblem = "crossed"
+	// LockedBook means the best Yes and No bids sum to exactly 100.
+	LockedBook IntegrityProblem = "locked"
+	// InvalidQuantity means a level has a zero or negative quantity.
+	InvalidQuantity IntegrityProblem = "invalid quantity"
+	// InvalidPrice means a level is priced outside of [1, 99].
+	InvalidPrice IntegrityProblem = "invalid price"
+)
+
+// IntegrityError describes an order book that cannot be real. For streamed
+// books, this usually signals a missed message.
+type IntegrityError struct {
+	Problem IntegrityProblem
+	// Side and Level locate the offending level of InvalidQuantity and
+	// InvalidPrice problems.
+	Side  Side
+	Level OrderBookBid
+	// YesBid and NoBid are the best bids of a CrossedBook or LockedBook.
+	YesBid Cents
+	NoBid  Cents
+}
+
+func (e *IntegrityError) Error() string {
+	switch e.Problem {
+	case CrossedBook, LockedBook:
+		return fmt.Sprintf("%s book: yes bid %d + no bid %d", e.Problem, e.YesBid, e.NoBid)
+	default:
+		return fmt.Sprintf("%s: %v %d x %d", e.Problem, e.Side, e.Level.Price, e.Level.Quantity)
+	}
+}
+
+// checkSpread checks the best bids of either side. Zero means a side has no
+// bids.
+func checkSpread(yesBid, noBid Cents) *IntegrityError {
+	if yesBid == 0 || noBid == 0 {
+		return nil
+	}
+	problem := CrossedBook
+	switch {
+	case yesBid+noBid < 100:
+		return nil
+	case yesBid+noBid == 100:
+		problem = LockedBook
+	}
+	return &IntegrityError{
+		Problem: problem,
+		YesBid:  yesBid,
+		NoBid:   noBid,
+	}
+}
+
+func (b OrderBookBids) checkLevels(side Side) (best Cents, err *IntegrityError) {
+	for _, v := range b {
+		switch {
+		case !validPrice(v.Price):
+			return 0, &IntegrityError{Problem: InvalidPrice, Side: side, Level: v}
+		case v.Quantity <= 0:
+			return 0, &IntegrityError{Problem: InvalidQuantity, Side: side, Level: v}
+		case v.Price > best:
+			best = v.Price
+		}
+	}
+	return best, nil
+}
+
+// CheckIntegrity returns an *IntegrityError if the book has levels with
+// invalid prices or quantities, or if it is crossed or locked.
+func (b OrderBook) CheckIntegrity() error {
+	yesBid, err := b.YesBids.checkLevels(Yes)
+	if err != nil {
+		return err
+	}
+	noBid, err := b.NoBids.checkLevels(No)
+	if err != nil {
+		return err
+	}
+	if err := checkSpread(yesBid, noBid); err != nil {
+		return err
+	}
+	return nil
 }
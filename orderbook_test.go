@@ -81,3 +81,217 @@ func TestParseOrderBook(t *testing.T) {
 		NoBids: OrderBookBids{},
 	}, book)
 }
+
+func TestSimulateFill(t *testing.T) {
+	t.Parallel()
+
+	book := OrderBook{
+		YesBids: OrderBookBids{
+			{40, 10},
+			{45, 5},
+			{50, 2},
+		},
+		NoBids: OrderBookBids{
+			{30, 4},
+		},
+	}
+
+	t.Run("SellProceeds", func(t *testing.T) {
+		t.Parallel()
+
+		proceeds, ok := book.YesSellProceeds(7)
+		require.True(t, ok)
+		require.Equal(t, Cents(2*50+5*45), proceeds)
+
+		proceeds, ok = book.NoSellProceeds(5)
+		require.False(t, ok)
+		require.Equal(t, Cents(4*30), proceeds)
+	})
+
+	t.Run("Levels", func(t *testing.T) {
+		t.Parallel()
+
+		fill, err := book.SimulateFill(Sell, Yes, 8, 0)
+		require.NoError(t, err)
+		require.True(t, fill.Complete())
+		require.Equal(t, 8, fill.Filled)
+		require.Equal(t, Cents(40), fill.WorstPrice)
+		require.Equal(t, Cents(2*50+5*45+40), fill.Total)
+		// 365 / 8 = 45.6, rounded down for sells.
+		require.Equal(t, Cents(45), fill.AveragePrice)
+		require.Equal(t, []FillLevel{
+			{50, 2},
+			{45, 5},
+			{40, 1},
+		}, fill.Levels)
+
+		fill, err = book.SimulateFill(Buy, No, 3, 0)
+		require.NoError(t, err)
+		require.True(t, fill.Complete())
+		// 50 + 50 + 55 = 155 / 3 = 51.6, rounded up for buys.
+		require.Equal(t, Cents(52), fill.AveragePrice)
+		require.Equal(t, Cents(55), fill.WorstPrice)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		t.Parallel()
+
+		fill, err := book.SimulateFill(Sell, Yes, 20, 45)
+		require.NoError(t, err)
+		require.False(t, fill.Complete())
+		require.Equal(t, 7, fill.Filled)
+		require.Equal(t, Cents(45), fill.WorstPrice)
+
+		fill, err = book.SimulateFill(Buy, Yes, 20, 60)
+		require.NoError(t, err)
+		require.Zero(t, fill.Filled)
+		require.Empty(t, fill.Levels)
+	})
+
+	t.Run("Inconsistent", func(t *testing.T) {
+		t.Parallel()
+
+		bad := OrderBook{
+			YesBids: OrderBookBids{
+				{60, 5},
+				{20, -3},
+				{0, 10},
+				{150, 10},
+				{70, 0},
+				{50, 5},
+			},
+		}
+		fill, err := bad.SimulateFill(Sell, Yes, 8, 0)
+		require.NoError(t, err)
+		require.Equal(t, []FillLevel{
+			{60, 5},
+			{50, 3},
+		}, fill.Levels)
+
+		_, ok := bad.BestNoOffer(0)
+		require.False(t, ok)
+		_, ok = bad.BestNoOffer(-1)
+		require.False(t, ok)
+
+		_, err = bad.SimulateFill(Buy, "maybe", 1, 0)
+		require.Error(t, err)
+		_, err = bad.SimulateFill("hold", Yes, 1, 0)
+		require.Error(t, err)
+	})
+}
+
+func TestParseOrderBookDollars(t *testing.T) {
+	t.Parallel()
+	var book OrderBook
+	str := `{"yes": [[19, 132]], "no": [], "yes_dollars": [["0.1900", 132]], "no_dollars": [[0.015, 4]]}`
+
+	err := json.Unmarshal([]byte(str), &book)
+	require.NoError(t, err)
+	require.Equal(t, OrderBookDollarBids{
+		{0.19, 132},
+	}, book.YesDollars)
+	require.Equal(t, OrderBookDollarBids{
+		{0.015, 4},
+	}, book.NoDollars)
+	require.Equal(t, Cents(19), book.YesDollars[0].Cents())
+
+	byt, err := json.Marshal(book.YesDollars[0])
+	require.NoError(t, err)
+	require.Equal(t, `["0.1900",132]`, string(byt))
+}
+
+func TestDiffOrderBooks(t *testing.T) {
+	t.Parallel()
+
+	from := OrderBook{
+		YesBids: OrderBookBids{
+			{10, 5},
+			{20, 5},
+		},
+		NoBids: OrderBookBids{
+			{60, 1},
+		},
+	}
+	to := OrderBook{
+		YesBids: OrderBookBids{
+			{20, 7},
+			{25, 1},
+		},
+		NoBids: OrderBookBids{
+			{60, 1},
+		},
+	}
+
+	deltas := DiffOrderBooks(from, to)
+	require.Equal(t, []BookDelta{
+		{Side: Yes, Price: 10, Delta: -5},
+		{Side: Yes, Price: 20, Delta: 2},
+		{Side: Yes, Price: 25, Delta: 1},
+	}, deltas)
+
+	got, err := from.ApplyDeltas(deltas)
+	require.NoError(t, err)
+	require.Equal(t, to, got)
+
+	// Reversing the diff restores the original.
+	got, err = to.ApplyDeltas(DiffOrderBooks(to, from))
+	require.NoError(t, err)
+	require.Equal(t, from, got)
+
+	require.Empty(t, DiffOrderBooks(to, to))
+
+	// Streamed state agrees with the polled result.
+	sob := makeOrderBookStreamState("duh")
+	require.NoError(t, sob.LoadBook(from))
+	for _, d := range deltas {
+		require.NoError(t, sob.ApplyDelta(d.Side, d.Price, d.Delta))
+	}
+	require.Equal(t, to, sob.OrderBook().OrderBook)
+
+	_, err = from.ApplyDeltas([]BookDelta{{Side: No, Price: 60, Delta: -2}})
+	require.Error(t, err)
+	_, err = from.ApplyDeltas([]BookDelta{{Side: "maybe", Price: 60, Delta: 1}})
+	require.Error(t, err)
+}
+
+func TestOrderBookCheckIntegrity(t *testing.T) {
+	t.Parallel()
+
+	requireProblem := func(want IntegrityProblem, book OrderBook) *IntegrityError {
+		t.Helper()
+		var ierr *IntegrityError
+		require.ErrorAs(t, book.CheckIntegrity(), &ierr)
+		require.Equal(t, want, ierr.Problem)
+		return ierr
+	}
+
+	require.NoError(t, OrderBook{}.CheckIntegrity())
+	require.NoError(t, OrderBook{
+		YesBids: OrderBookBids{{40, 1}, {45, 1}},
+		NoBids:  OrderBookBids{{54, 1}},
+	}.CheckIntegrity())
+
+	ierr := requireProblem(LockedBook, OrderBook{
+		YesBids: OrderBookBids{{40, 1}, {45, 1}},
+		NoBids:  OrderBookBids{{55, 1}},
+	})
+	require.Equal(t, Cents(45), ierr.YesBid)
+	require.Equal(t, Cents(55), ierr.NoBid)
+
+	requireProblem(CrossedBook, OrderBook{
+		YesBids: OrderBookBids{{60, 1}},
+		NoBids:  OrderBookBids{{55, 1}},
+	})
+
+	ierr = requireProblem(InvalidQuantity, OrderBook{
+		NoBids: OrderBookBids{{55, 0}},
+	})
+	require.Equal(t, No, ierr.Side)
+
+	requireProblem(InvalidQuantity, OrderBook{
+		YesBids: OrderBookBids{{55, -1}},
+	})
+	requireProblem(InvalidPrice, OrderBook{
+		YesBids: OrderBookBids{{100, 1}},
+	})
+}
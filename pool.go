@@ -0,0 +1,376 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+)
+
+// ShardPolicy decides which Feed of a FeedPool serves a market.
+type ShardPolicy int
+
+const (
+	// ShardByHash assigns markets by hashing their tickers, so that a market
+	// is always served by the same Feed while that Feed is connected.
+	ShardByHash ShardPolicy = iota
+	// ShardByLoad assigns markets to the connected Feed that serves the
+	// fewest markets.
+	ShardByLoad
+)
+
+func (p ShardPolicy) String() string {
+	switch p {
+	case ShardByHash:
+		return "hash"
+	case ShardByLoad:
+		return "load"
+	default:
+		return "unknown"
+	}
+}
+
+// FeedPool spreads market subscriptions across several Feeds, so that a
+// single connection does not limit the number of markets that can be
+// followed. The streams of a FeedPool merge the messages of every Feed onto
+// one channel.
+//
+// Markets move off a Feed while it is reconnecting or once it closes, and
+// are rebalanced across the pool when it reconnects. Moved markets are
+// resubscribed on their new Feed, so books restart with a snapshot and
+// trades and tickers may be missed while markets move.
+//
+// Configure the Feeds of the pool through Feeds before the first stream.
+type FeedPool struct {
+	// Sharding decides which Feed serves a market. It must be set before
+	// the first stream.
+	Sharding ShardPolicy
+
+	feeds []*Feed
+
+	mu sync.Mutex
+	// load counts the markets assigned to each Feed across streams.
+	load []int
+}
+
+// NewFeedPool spreads subscriptions across feeds.
+func NewFeedPool(feeds ...*Feed) *FeedPool {
+	return &FeedPool{
+		feeds: feeds,
+		load:  make([]int, len(feeds)),
+	}
+}
+
+// OpenFeedPool opens n Feeds and pools them.
+func (c *Client) OpenFeedPool(ctx context.Context, n int) (*FeedPool, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("pool needs at least one feed, got %v", n)
+	}
+	feeds := make([]*Feed, 0, n)
+	for i := 0; i < n; i++ {
+		f, err := c.OpenFeed(ctx)
+		if err != nil {
+			for _, f := range feeds {
+				_ = f.Close()
+			}
+			return nil, fmt.Errorf("open feed %v: %w", i, err)
+		}
+		feeds = append(feeds, f)
+	}
+	return NewFeedPool(feeds...), nil
+}
+
+// Feeds returns the Feeds of the pool.
+func (p *FeedPool) Feeds() []*Feed {
+	return append([]*Feed(nil), p.feeds...)
+}
+
+// Close closes every Feed of the pool and returns the first error.
+func (p *FeedPool) Close() error {
+	var firstErr error
+	for _, f := range p.feeds {
+		err := f.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Books streams the order books of marketTickers across the pool. See
+// Feed.Books.
+func (p *FeedPool) Books(ctx context.Context, marketTickers []string, feed chan<- *StreamOrderBook) error {
+	return p.stream(ctx, marketTickers, func(ctx context.Context, f *Feed, marketTickers []string) error {
+		return f.Books(ctx, marketTickers, feed)
+	})
+}
+
+// Trades streams the trades of marketTickers across the pool. See
+// Feed.Trades.
+func (p *FeedPool) Trades(ctx context.Context, marketTickers []string, feed chan<- Trade) error {
+	return p.stream(ctx, marketTickers, func(ctx context.Context, f *Feed, marketTickers []string) error {
+		return f.Trades(ctx, marketTickers, feed)
+	})
+}
+
+// Tickers streams the tickers of marketTickers across the pool. See
+// Feed.Tickers.
+func (p *FeedPool) Tickers(ctx context.Context, marketTickers []string, feed chan<- Ticker) error {
+	return p.stream(ctx, marketTickers, func(ctx context.Context, f *Feed, marketTickers []string) error {
+		return f.Tickers(ctx, marketTickers, feed)
+	})
+}
+
+// shard is the part of a pool stream served by one Feed.
+type shard struct {
+	marketTickers []string
+	cancel        context.CancelFunc
+}
+
+type shardResult struct {
+	feed  int
+	shard *shard
+	err   error
+}
+
+// stream runs a stream of marketTickers on the Feeds that serve them, and
+// moves markets between Feeds whenever the state of a Feed changes. The
+// stream ends once ctx is done, every market was unsubscribed, a shard fails
+// on a healthy Feed, or every Feed closed.
+func (p *FeedPool) stream(ctx context.Context, marketTickers []string, run func(ctx context.Context, f *Feed, marketTickers []string) error) error {
+	if len(p.feeds) == 0 {
+		return fmt.Errorf("no feeds")
+	}
+	if len(marketTickers) == 0 {
+		return fmt.Errorf("no market tickers")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	changed := make(chan struct{}, 1)
+	for _, f := range p.feeds {
+		stop := f.listenState(func(FeedState, error) {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+		defer stop()
+	}
+
+	// assign maps every market to the index of the Feed serving it, or -1.
+	assign := make(map[string]int, len(marketTickers))
+	for _, ticker := range marketTickers {
+		assign[ticker] = -1
+	}
+	defer p.release(assign)
+
+	var (
+		shards  = make([]*shard, len(p.feeds))
+		results = make(chan shardResult)
+		running int
+	)
+	defer func() {
+		cancel()
+		for ; running > 0; running-- {
+			<-results
+		}
+	}()
+
+	for {
+		p.mu.Lock()
+		p.rebalance(assign, p.available())
+		p.mu.Unlock()
+
+		want := make([][]string, len(p.feeds))
+		for ticker, i := range assign {
+			if i >= 0 {
+				want[i] = append(want[i], ticker)
+			}
+		}
+		for i, f := range p.feeds {
+			sort.Strings(want[i])
+			if shards[i] != nil && equalStrings(shards[i].marketTickers, want[i]) {
+				continue
+			}
+			if shards[i] != nil {
+				shards[i].cancel()
+				shards[i] = nil
+			}
+			if len(want[i]) == 0 || f.Err() != nil {
+				continue
+			}
+
+			shardCtx, shardCancel := context.WithCancel(ctx)
+			sh := &shard{marketTickers: want[i], cancel: shardCancel}
+			shards[i] = sh
+			running++
+			go func(i int, f *Feed) {
+				results <- shardResult{feed: i, shard: sh, err: run(shardCtx, f, sh.marketTickers)}
+			}(i, f)
+		}
+
+		if p.closed() {
+			return fmt.Errorf("every feed closed: %w", p.feeds[0].Err())
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case r := <-results:
+			running--
+			if shards[r.feed] != r.shard {
+				// The shard was replaced.
+				continue
+			}
+			shards[r.feed] = nil
+			r.shard.cancel()
+			switch {
+			case r.err == nil:
+				// The shard was unsubscribed through its Feed.
+				p.mu.Lock()
+				for _, ticker := range r.shard.marketTickers {
+					p.load[r.feed]--
+					delete(assign, ticker)
+				}
+				p.mu.Unlock()
+				if len(assign) == 0 {
+					return nil
+				}
+			case p.feeds[r.feed].Err() != nil:
+				// The markets move to another Feed.
+			default:
+				return r.err
+			}
+		}
+	}
+}
+
+// available reports which Feeds can take markets.
+func (p *FeedPool) available() []bool {
+	ok := make([]bool, len(p.feeds))
+	for i, f := range p.feeds {
+		ok[i] = f.available()
+	}
+	return ok
+}
+
+// closed reports whether every Feed of the pool stopped for good.
+func (p *FeedPool) closed() bool {
+	for _, f := range p.feeds {
+		if f.Err() == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// available reports whether the Feed is connected and open.
+func (s *Feed) available() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state == FeedConnected && s.err == nil && !s.closed
+}
+
+// rebalance assigns the markets of a stream to available Feeds. Markets stay
+// on unavailable Feeds if no Feed is available. p.mu must be held.
+func (p *FeedPool) rebalance(assign map[string]int, available []bool) {
+	tickers := make([]string, 0, len(assign))
+	for ticker := range assign {
+		tickers = append(tickers, ticker)
+	}
+	sort.Strings(tickers)
+
+	move := func(ticker string, to int) {
+		if from := assign[ticker]; from >= 0 {
+			p.load[from]--
+		}
+		assign[ticker] = to
+		p.load[to]++
+	}
+
+	switch p.Sharding {
+	case ShardByLoad:
+		for _, ticker := range tickers {
+			if i := assign[ticker]; i >= 0 && available[i] {
+				continue
+			}
+			if to := p.leastLoaded(available); to >= 0 {
+				move(ticker, to)
+			}
+		}
+		// Moving markets one at a time from the Feeds above the least
+		// loaded one evens out the load within one.
+		for _, ticker := range tickers {
+			from := assign[ticker]
+			to := p.leastLoaded(available)
+			if from >= 0 && to >= 0 && p.load[from]-p.load[to] > 1 {
+				move(ticker, to)
+			}
+		}
+	default:
+		for _, ticker := range tickers {
+			to := shardByHash(ticker, available)
+			if to >= 0 && to != assign[ticker] {
+				move(ticker, to)
+			}
+		}
+	}
+}
+
+// leastLoaded returns the available Feed with the fewest markets, or -1.
+func (p *FeedPool) leastLoaded(available []bool) int {
+	best := -1
+	for i, ok := range available {
+		if ok && (best < 0 || p.load[i] < p.load[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// shardByHash returns the available Feed with the highest hash weight for
+// ticker, or -1. Weighing every Feed only moves the markets of Feeds that
+// change availability.
+func shardByHash(ticker string, available []bool) int {
+	var (
+		best       = -1
+		bestWeight uint64
+	)
+	for i, ok := range available {
+		if !ok {
+			continue
+		}
+		h := fnv.New64a()
+		fmt.Fprintf(h, "%v/%s", i, ticker)
+		if w := h.Sum64(); best < 0 || w > bestWeight {
+			best, bestWeight = i, w
+		}
+	}
+	return best
+}
+
+// release unassigns the markets of a stream that ended.
+func (p *FeedPool) release(assign map[string]int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, i := range assign {
+		if i >= 0 {
+			p.load[i]--
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
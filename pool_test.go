@@ -0,0 +1,333 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+	"nhooyr.io/websocket/wsjson"
+)
+
+func TestFeedPool_rebalance(t *testing.T) {
+	t.Parallel()
+
+	tickers := []string{"A", "B", "C", "D", "E", "F", "G", "H"}
+	newAssign := func() map[string]int {
+		assign := make(map[string]int)
+		for _, ticker := range tickers {
+			assign[ticker] = -1
+		}
+		return assign
+	}
+	loads := func(assign map[string]int) []int {
+		load := make([]int, 3)
+		for _, i := range assign {
+			load[i]++
+		}
+		return load
+	}
+	copyAssign := func(assign map[string]int) map[string]int {
+		c := make(map[string]int, len(assign))
+		for k, v := range assign {
+			c[k] = v
+		}
+		return c
+	}
+
+	t.Run("Hash", func(t *testing.T) {
+		t.Parallel()
+
+		p := NewFeedPool(nil, nil, nil)
+		assign := newAssign()
+		p.rebalance(assign, []bool{true, true, true})
+		before := copyAssign(assign)
+		require.Equal(t, loads(assign), p.load)
+
+		// Only the markets of the lost Feed move.
+		p.rebalance(assign, []bool{true, false, true})
+		for ticker, i := range before {
+			if i != 1 {
+				require.Equal(t, i, assign[ticker], ticker)
+			} else {
+				require.NotEqual(t, 1, assign[ticker], ticker)
+			}
+		}
+		require.Equal(t, loads(assign), p.load)
+		require.Zero(t, p.load[1])
+
+		// They move back once it reconnects.
+		p.rebalance(assign, []bool{true, true, true})
+		require.Equal(t, before, assign)
+
+		// Markets stay put if no Feed is available.
+		p.rebalance(assign, []bool{false, false, false})
+		require.Equal(t, before, assign)
+
+		p.release(assign)
+		require.Equal(t, []int{0, 0, 0}, p.load)
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		t.Parallel()
+
+		p := NewFeedPool(nil, nil, nil)
+		p.Sharding = ShardByLoad
+		p.load[0] = 2 // Another stream.
+		assign := newAssign()
+		p.rebalance(assign, []bool{true, true, true})
+		require.Equal(t, []int{2, 3, 3}, loads(assign))
+		require.Equal(t, []int{4, 3, 3}, p.load)
+
+		p.rebalance(assign, []bool{true, true, false})
+		require.Equal(t, []int{3, 5, 0}, loads(assign))
+		require.Equal(t, []int{5, 5, 0}, p.load)
+
+		p.rebalance(assign, []bool{true, true, true})
+		require.Equal(t, []int{2, 3, 3}, loads(assign))
+		require.Equal(t, []int{4, 3, 3}, p.load)
+
+		p.release(assign)
+		require.Equal(t, []int{2, 0, 0}, p.load)
+	})
+}
+
+// testExchange is a local stand-in for the exchange that serves order books
+// for any market.
+type testExchange struct {
+	srv *httptest.Server
+	// hold, if set, blocks connections after the first until it is closed.
+	hold chan struct{}
+
+	mu    sync.Mutex
+	conns int
+	// drop is closed to drop the current connection.
+	drop chan struct{}
+	// markets holds the markets of the subscriptions of the current
+	// connection by sid.
+	markets map[int][]string
+}
+
+func newTestExchange(t *testing.T) *testExchange {
+	t.Helper()
+
+	e := &testExchange{
+		drop:    make(chan struct{}),
+		markets: make(map[int][]string),
+	}
+	serveCtx, cancel := context.WithCancel(context.Background())
+	e.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mu.Lock()
+		e.conns++
+		n := e.conns
+		e.mu.Unlock()
+		if n > 1 && e.hold != nil {
+			select {
+			case <-e.hold:
+			case <-serveCtx.Done():
+				return
+			}
+		}
+
+		c, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		defer c.Close(websocket.StatusInternalError, "")
+		e.serve(serveCtx, t, c)
+	}))
+	t.Cleanup(func() {
+		cancel()
+		e.srv.Close()
+	})
+	return e
+}
+
+func (e *testExchange) serve(ctx context.Context, t *testing.T, c *websocket.Conn) {
+	e.mu.Lock()
+	drop := e.drop
+	e.markets = make(map[int][]string)
+	e.mu.Unlock()
+	defer func() {
+		e.mu.Lock()
+		e.markets = make(map[int][]string)
+		e.mu.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-drop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var sid int
+	for ctx.Err() == nil {
+		var cmd command
+		err := wsjson.Read(ctx, c, &cmd)
+		if err != nil {
+			return
+		}
+		switch cmd.Command {
+		case "subscribe":
+			sid++
+			tickers := cmd.Params.marketTickers()
+			e.mu.Lock()
+			e.markets[sid] = tickers
+			e.mu.Unlock()
+			writeMessages(ctx, t, c, fmt.Sprintf(testSubscribed, cmd.ID, sid))
+			for i, ticker := range tickers {
+				writeMessages(ctx, t, c, fmt.Sprintf(
+					`{"type": "orderbook_snapshot", "sid": %d, "seq": %d, "msg": {"market_ticker": %q, "yes": [[40, 10]], "no": []}}`,
+					sid, i+1, ticker,
+				))
+			}
+		case "unsubscribe":
+			e.mu.Lock()
+			for _, sid := range cmd.Params.Sids {
+				delete(e.markets, sid)
+			}
+			e.mu.Unlock()
+			writeMessages(ctx, t, c, fmt.Sprintf(
+				`{"id": %d, "type": "unsubscribed", "sid": %d}`, cmd.ID, cmd.Params.Sids[0],
+			))
+		}
+	}
+}
+
+// feed opens a Feed on the exchange.
+func (e *testExchange) feed(t *testing.T) *Feed {
+	t.Helper()
+
+	dial := func(ctx context.Context) (*websocket.Conn, error) {
+		c, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(e.srv.URL, "http"), nil)
+		return c, err
+	}
+	c, err := dial(context.Background())
+	require.NoError(t, err)
+	f := newFeed(c, dial, nil)
+	t.Cleanup(func() {
+		_ = f.Close()
+	})
+	return f
+}
+
+// dropConn drops the current connection without a close handshake.
+func (e *testExchange) dropConn() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	close(e.drop)
+	e.drop = make(chan struct{})
+}
+
+// subscribed returns the markets subscribed on the current connection.
+func (e *testExchange) subscribed() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var tickers []string
+	for _, markets := range e.markets {
+		tickers = append(tickers, markets...)
+	}
+	sort.Strings(tickers)
+	return tickers
+}
+
+func TestFeedPool(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tickers := []string{"A", "B", "C", "D"}
+	exchanges := []*testExchange{newTestExchange(t), newTestExchange(t)}
+	exchanges[1].hold = make(chan struct{})
+
+	p := NewFeedPool(exchanges[0].feed(t), exchanges[1].feed(t))
+	p.Sharding = ShardByLoad
+	p.Feeds()[1].Reconnect = &ReconnectPolicy{MinBackoff: time.Millisecond}
+
+	var (
+		mu        sync.Mutex
+		snapshots = make(map[string]int)
+	)
+	books := make(chan *StreamOrderBook)
+	go func() {
+		for book := range books {
+			mu.Lock()
+			snapshots[book.MarketID]++
+			mu.Unlock()
+		}
+	}()
+	requireSnapshots := func(want map[string]int) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			for ticker, n := range want {
+				if snapshots[ticker] < n {
+					return false
+				}
+			}
+			return true
+		}, time.Second*5, time.Millisecond*5)
+	}
+	requireShards := func(want ...int) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			var all []string
+			for i, e := range exchanges {
+				got := e.subscribed()
+				if len(got) != want[i] {
+					return false
+				}
+				all = append(all, got...)
+			}
+			sort.Strings(all)
+			return equalStrings(all, tickers)
+		}, time.Second*5, time.Millisecond*5)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- p.Books(ctx, tickers, books)
+	}()
+
+	// Every market is delivered onto one channel from both connections.
+	requireShards(2, 2)
+	requireSnapshots(map[string]int{"A": 1, "B": 1, "C": 1, "D": 1})
+	moved := exchanges[1].subscribed()
+
+	// Markets move off the Feed while it is reconnecting...
+	exchanges[1].dropConn()
+	require.Eventually(t, func() bool {
+		return len(exchanges[0].subscribed()) == 4
+	}, time.Second*5, time.Millisecond*5)
+	requireSnapshots(map[string]int{moved[0]: 2, moved[1]: 2})
+
+	// ...and are rebalanced once it reconnects.
+	close(exchanges[1].hold)
+	requireShards(2, 2)
+
+	// Markets of a Feed that closes move for good.
+	require.NoError(t, p.Feeds()[0].Close())
+	requireShards(0, 4)
+
+	require.NoError(t, p.Feeds()[1].Close())
+	select {
+	case err := <-errs:
+		require.ErrorContains(t, err, "every feed closed")
+	case <-time.After(time.Second * 5):
+		t.Fatal("stream did not end")
+	}
+}
@@ -2,12 +2,14 @@ package kalshi
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/time/rate"
 )
 
 type OrderStatus string
@@ -133,6 +135,142 @@ func (c *Client) CreateOrder(ctx context.Context, req CreateOrderRequest) (*Orde
 	return &resp.Order, nil
 }
 
+// MaxBatchOrders is the maximum number of orders the exchange accepts in a
+// single batch.
+const MaxBatchOrders = 20
+
+// BatchOrderError is the reason the exchange rejected an order of a batch.
+type BatchOrderError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details string `json:"details"`
+}
+
+func (e *BatchOrderError) Error() string {
+	if e.Details != "" {
+		return fmt.Sprintf("%s: %s (%s)", e.Code, e.Message, e.Details)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// BatchOrderResult is the outcome of an order passed to BatchCreateOrders.
+type BatchOrderResult struct {
+	// ClientOrderID identifies the order, including orders that failed.
+	ClientOrderID string
+	// Order is set if the order was created.
+	Order *Order
+	// Err is set if the order was not confirmed. It is a *BatchOrderError
+	// if the exchange rejected the order, and matches ErrRateLimited if the
+	// batch was never sent because of WriteRatelimit. Either way the order
+	// was not created. Otherwise it is the error of the request that
+	// carried the order, and the order may still have been created, such as
+	// after a timeout, a broken connection or a server error. Look the
+	// order up by its ClientOrderID, such as with Orders, before sending it
+	// again.
+	Err error
+}
+
+// BatchCreateOrders is described here:
+// https://trading-api.readme.io/reference/batchcreateorders.
+//
+// Orders without a ClientOrderID are assigned one, as with CreateOrder.
+// Orders are sent in batches of up to MaxBatchOrders, and a batch that
+// fails does not stop the batches after it. Every order takes a token of
+// WriteRatelimit, so batches are also kept within its burst. The results are in the order
+// of reqs. The returned error joins the errors of every failed order, so a
+// partial failure returns both an error and the created orders.
+//
+// An order whose result has an Err other than a *BatchOrderError or
+// ErrRateLimited has an unknown outcome. Reconcile such orders by
+// ClientOrderID before retrying them, or they may be placed twice.
+func (c *Client) BatchCreateOrders(ctx context.Context, reqs []CreateOrderRequest) ([]BatchOrderResult, error) {
+	reqs = append([]CreateOrderRequest(nil), reqs...)
+	results := make([]BatchOrderResult, len(reqs))
+	for i := range reqs {
+		if reqs[i].ClientOrderID == "" {
+			reqs[i].ClientOrderID = uuid.New().String()
+		}
+		results[i].ClientOrderID = reqs[i].ClientOrderID
+	}
+
+	size := c.batchSize()
+	var errs []error
+	for start := 0; start < len(reqs); start += size {
+		end := start + size
+		if end > len(reqs) {
+			end = len(reqs)
+		}
+		err := ctx.Err()
+		if err == nil {
+			err = c.batchCreateOrders(ctx, reqs[start:end], results[start:end])
+		}
+		if err != nil {
+			for i := start; i < end; i++ {
+				results[i].Err = err
+			}
+			errs = append(errs, fmt.Errorf("orders %d-%d: %w", start, end-1, err))
+			continue
+		}
+		for _, r := range results[start:end] {
+			if r.Err != nil {
+				errs = append(errs, fmt.Errorf("order %s: %w", r.ClientOrderID, r.Err))
+			}
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// batchSize returns the number of orders to send in a batch. A batch
+// larger than the burst of WriteRatelimit would never be allowed.
+func (c *Client) batchSize() int {
+	burst := c.WriteRatelimit.Burst()
+	if c.WriteRatelimit.Limit() == rate.Inf || burst >= MaxBatchOrders {
+		return MaxBatchOrders
+	}
+	if burst < 1 {
+		return 1
+	}
+	return burst
+}
+
+// batchCreateOrders sends a single batch and fills in the result of every
+// order it accepted or rejected.
+func (c *Client) batchCreateOrders(ctx context.Context, reqs []CreateOrderRequest, results []BatchOrderResult) error {
+	var resp struct {
+		Orders []struct {
+			Order *Order           `json:"order"`
+			Error *BatchOrderError `json:"error"`
+		} `json:"orders"`
+	}
+	err := c.request(ctx, request{
+		Method:   "POST",
+		Endpoint: "portfolio/orders/batched",
+		JSONRequest: struct {
+			Orders []CreateOrderRequest `json:"orders"`
+		}{reqs},
+		JSONResponse: &resp,
+		Cost:         len(reqs),
+	})
+	if err != nil {
+		return err
+	}
+	if len(resp.Orders) != len(reqs) {
+		return fmt.Errorf("got %d results for %d orders", len(resp.Orders), len(reqs))
+	}
+
+	for i, r := range resp.Orders {
+		switch {
+		case r.Error != nil:
+			results[i].Err = r.Error
+		case r.Order != nil:
+			results[i].Order = r.Order
+		default:
+			results[i].Err = fmt.Errorf("no order in result")
+		}
+	}
+	return nil
+}
+
 // OrdersRequest is described here:
 // https://trading-api.readme.io/reference/getorders
 type OrdersRequest struct {
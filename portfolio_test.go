@@ -2,10 +2,15 @@ package kalshi
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
 )
 
 func TestBalance(t *testing.T) {
@@ -38,7 +43,7 @@ func TestOrder(t *testing.T) {
 
 	testMarket := highestVolumeMarkets(ctx, t, client)[0]
 
-	book, err := client.MarketOrderBook(ctx, testMarket.Ticker)
+	book, err := client.MarketOrderBook(ctx, testMarket.Ticker, MarketOrderBookRequest{})
 	require.NoError(t, err)
 	t.Logf("book for %s: %+v", testMarket.Ticker, book)
 	if len(book.NoBids) > 0 {
@@ -155,3 +160,150 @@ func TestSettlements(t *testing.T) {
 	_, err := client.Settlements(ctx, SettlementsRequest{})
 	require.NoError(t, err)
 }
+
+func TestBatchCreateOrders(t *testing.T) {
+	t.Parallel()
+
+	var batches [][]CreateOrderRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/portfolio/orders/batched", r.URL.Path)
+		var req struct {
+			Orders []CreateOrderRequest `json:"orders"`
+		}
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+			return
+		}
+		batches = append(batches, req.Orders)
+		if len(batches) == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		type result struct {
+			Order *Order           `json:"order,omitempty"`
+			Error *BatchOrderError `json:"error,omitempty"`
+		}
+		var resp struct {
+			Orders []result `json:"orders"`
+		}
+		for _, o := range req.Orders {
+			if o.Count == 0 {
+				resp.Orders = append(resp.Orders, result{Error: &BatchOrderError{
+					Code:    "invalid_parameters",
+					Message: "count must be positive",
+				}})
+				continue
+			}
+			resp.Orders = append(resp.Orders, result{Order: &Order{
+				OrderID:       "order-" + o.ClientOrderID,
+				ClientOrderID: o.ClientOrderID,
+				Ticker:        o.Ticker,
+			}})
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	reqs := make([]CreateOrderRequest, MaxBatchOrders*2+5)
+	for i := range reqs {
+		reqs[i] = CreateOrderRequest{
+			Action: Buy,
+			Count:  1,
+			Ticker: "DUH",
+			Type:   LimitOrder,
+			Side:   Yes,
+		}
+	}
+	reqs[0].ClientOrderID = "mine"
+	reqs[3].Count = 0
+
+	client := New(srv.URL + "/")
+	client.WriteRatelimit = rate.NewLimiter(rate.Inf, 0)
+	results, err := client.BatchCreateOrders(context.Background(), reqs)
+	require.Error(t, err)
+	require.Len(t, results, len(reqs))
+	require.Len(t, batches, 3)
+	require.Len(t, batches[0], MaxBatchOrders)
+	require.Len(t, batches[1], MaxBatchOrders)
+	require.Len(t, batches[2], 5)
+	// The requests of the caller are left untouched.
+	require.Empty(t, reqs[1].ClientOrderID)
+
+	ids := make(map[string]bool)
+	for i, r := range results {
+		require.NotEmpty(t, r.ClientOrderID)
+		require.False(t, ids[r.ClientOrderID], "duplicate client order ID")
+		ids[r.ClientOrderID] = true
+		require.Equal(t, r.ClientOrderID, batches[i/MaxBatchOrders][i%MaxBatchOrders].ClientOrderID)
+
+		switch {
+		case i == 3:
+			var berr *BatchOrderError
+			require.ErrorAs(t, r.Err, &berr)
+			require.Equal(t, "invalid_parameters", berr.Code)
+			require.Nil(t, r.Order)
+		case i >= MaxBatchOrders && i < MaxBatchOrders*2:
+			// The second batch failed as a whole.
+			require.ErrorContains(t, r.Err, "500")
+			require.Nil(t, r.Order)
+		default:
+			require.NoError(t, r.Err)
+			require.Equal(t, "order-"+r.ClientOrderID, r.Order.OrderID)
+		}
+	}
+	require.Equal(t, "mine", results[0].ClientOrderID)
+	require.ErrorContains(t, err, "order "+results[3].ClientOrderID+": invalid_parameters: count must be positive")
+	require.ErrorContains(t, err, "orders 20-39: unexpected status")
+}
+
+func TestBatchCreateOrdersRateLimit(t *testing.T) {
+	t.Parallel()
+
+	var batches [][]CreateOrderRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Orders []CreateOrderRequest `json:"orders"`
+		}
+		if !assert.NoError(t, json.NewDecoder(r.Body).Decode(&req)) {
+			return
+		}
+		batches = append(batches, req.Orders)
+		var resp struct {
+			Orders []struct {
+				Order *Order `json:"order"`
+			} `json:"orders"`
+		}
+		resp.Orders = make([]struct {
+			Order *Order `json:"order"`
+		}, len(req.Orders))
+		for i, o := range req.Orders {
+			resp.Orders[i].Order = &Order{OrderID: "order-" + o.ClientOrderID, ClientOrderID: o.ClientOrderID}
+		}
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	reqs := make([]CreateOrderRequest, 12)
+	for i := range reqs {
+		reqs[i] = CreateOrderRequest{Action: Buy, Count: 1, Ticker: "DUH", Type: LimitOrder, Side: Yes}
+	}
+
+	client := New(srv.URL + "/")
+	// Every order takes a token, so only the first batch fits.
+	client.WriteRatelimit = rate.NewLimiter(rate.Every(time.Hour), 5)
+	results, err := client.BatchCreateOrders(context.Background(), reqs)
+	require.ErrorIs(t, err, ErrRateLimited)
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 5)
+	for i, r := range results {
+		if i < 5 {
+			require.NoError(t, r.Err)
+			require.NotNil(t, r.Order)
+			continue
+		}
+		// The other batches were never sent.
+		require.ErrorIs(t, r.Err, ErrRateLimited)
+		require.Nil(t, r.Order)
+	}
+}
@@ -0,0 +1,230 @@
+package kalshi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// FeedState is the state of the connection of a Feed.
+type FeedState int
+
+const (
+	// FeedConnected means the Feed is reading from a live connection.
+	FeedConnected FeedState = iota
+	// FeedReconnecting means the connection was lost and the Feed is
+	// dialing a new one. Subscriptions are restored once it reconnects.
+	FeedReconnecting
+	// FeedClosed means the Feed stopped for good. See Err.
+	FeedClosed
+)
+
+func (s FeedState) String() string {
+	switch s {
+	case FeedConnected:
+		return "connected"
+	case FeedReconnecting:
+		return "reconnecting"
+	case FeedClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ReconnectPolicy configures how a Feed recovers from failures.
+//
+// When the connection drops, the Feed dials a new one with exponential
+// backoff and re-issues every active subscription. Streamed books are
+// emptied and reloaded from the snapshots sent on resubscription.
+//
+// When a sequence gap is detected, the affected subscription is replaced to
+// receive fresh snapshots.
+type ReconnectPolicy struct {
+	// MinBackoff is the delay before the second connection attempt. It
+	// doubles with every failed attempt up to MaxBackoff. They default to
+	// 100ms and 30s respectively.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxAttempts is the number of consecutive failed connection attempts
+	// after which the Feed gives up. Zero retries forever.
+	MaxAttempts int
+}
+
+// backoff returns the delay before the given connection attempt, starting
+// at zero.
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	if attempt == 0 {
+		return 0
+	}
+	var (
+		min = p.MinBackoff
+		max = p.MaxBackoff
+	)
+	if min <= 0 {
+		min = time.Millisecond * 100
+	}
+	if max <= 0 {
+		max = time.Second * 30
+	}
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// State returns the current state of the connection.
+func (s *Feed) State() FeedState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+func (s *Feed) setState(state FeedState, err error) {
+	s.mu.Lock()
+	changed := s.state != state
+	s.state = state
+	listeners := make([]stateListener, len(s.stateListeners))
+	copy(listeners, s.stateListeners)
+	s.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if s.OnStateChange != nil {
+		s.OnStateChange(state, err)
+	}
+	for _, l := range listeners {
+		l.fn(state, err)
+	}
+}
+
+type stateListener struct {
+	id int
+	fn func(state FeedState, err error)
+}
+
+// listenState calls fn on every state change until the returned function is
+// called.
+func (s *Feed) listenState(fn func(state FeedState, err error)) (stop func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextListener++
+	id := s.nextListener
+	s.stateListeners = append(s.stateListeners, stateListener{id: id, fn: fn})
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, l := range s.stateListeners {
+			if l.id == id {
+				s.stateListeners = append(s.stateListeners[:i:i], s.stateListeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// reconnects reports whether the reader should reconnect after the
+// connection fails.
+func (s *Feed) reconnects(ctx context.Context) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Reconnect != nil && !s.closed && ctx.Err() == nil
+}
+
+// reconnect replaces the failed connection and schedules every active
+// subscription to be resubscribed.
+func (s *Feed) reconnect(ctx context.Context, cause error) (*websocket.Conn, error) {
+	s.mu.Lock()
+	// Responses to in-flight commands will never arrive.
+	for id, p := range s.pending {
+		p.err <- cause
+		delete(s.pending, id)
+	}
+	// Sids are only meaningful on the connection that assigned them.
+	for sub := range s.active {
+		sub.sid = 0
+	}
+	s.subs = make(map[int]*subscription)
+	old := s.c
+	s.mu.Unlock()
+
+	// The connection may still be open if the failure was ours.
+	go old.Close(websocket.StatusGoingAway, "reconnecting")
+
+	s.setState(FeedReconnecting, cause)
+
+	var err error
+	for attempt := 0; s.Reconnect.MaxAttempts == 0 || attempt < s.Reconnect.MaxAttempts; attempt++ {
+		t := time.NewTimer(s.Reconnect.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+
+		var c *websocket.Conn
+		c, err = s.dial(ctx)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = c.Close(websocket.StatusNormalClosure, "")
+			return nil, errors.New("feed closed")
+		}
+		s.c = c
+		s.lastMessage = time.Now()
+		s.mu.Unlock()
+
+		s.reconnectCount.Add(1)
+		s.setState(FeedConnected, nil)
+		select {
+		case s.resubscribe <- struct{}{}:
+		default:
+		}
+		return c, nil
+	}
+	return nil, fmt.Errorf("reconnect: %w", err)
+}
+
+// resubscribeLoop restores subscriptions after every reconnect. A single
+// goroutine does this so that a subscription is never issued twice.
+func (s *Feed) resubscribeLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.resubscribe:
+		}
+
+		s.mu.Lock()
+		var subs []*subscription
+		for sub := range s.active {
+			if sub.sid == 0 && !sub.dropped {
+				subs = append(subs, sub)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, sub := range subs {
+			err := s.subscribe(ctx, sub)
+			var ferr *FeedError
+			if errors.As(err, &ferr) {
+				s.endSubscription(sub, err)
+			}
+			// Other errors mean the connection failed again, and the next
+			// reconnect retries.
+		}
+	}
+}
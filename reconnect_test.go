@@ -0,0 +1,208 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestReconnectPolicyBackoff(t *testing.T) {
+	t.Parallel()
+
+	p := &ReconnectPolicy{MinBackoff: time.Second, MaxBackoff: time.Second * 5}
+	var got []time.Duration
+	for attempt := 0; attempt < 6; attempt++ {
+		got = append(got, p.backoff(attempt))
+	}
+	require.Equal(t, []time.Duration{
+		0, time.Second, time.Second * 2, time.Second * 4, time.Second * 5, time.Second * 5,
+	}, got)
+
+	require.Equal(t, time.Millisecond*100, (&ReconnectPolicy{}).backoff(1))
+}
+
+const (
+	testSubscribed = `{"id": %d, "type": "subscribed", "msg": {"channel": "orderbook_delta", "sid": %d}}`
+	testSnapshot   = `{"type": "orderbook_snapshot", "sid": %d, "seq": 1, "msg": {"market_ticker": "DUH", "yes": [[%d, 10]], "no": []}}`
+	testDelta      = `{"type": "orderbook_delta", "sid": %d, "seq": %d, "msg": {"market_ticker": "DUH", "price": %d, "delta": 5, "side": "yes"}}`
+)
+
+func TestFeedReconnect(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		conns int
+	)
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		mu.Lock()
+		conns++
+		n := conns
+		mu.Unlock()
+
+		cmd := readCommand(ctx, t, c)
+		assert.Equal(t, "subscribe", cmd.Command)
+		assert.Equal(t, "DUH", cmd.Params.MarketTicker)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, n),
+			fmt.Sprintf(testSnapshot, n, 40+n),
+		)
+		if n == 1 {
+			// Drop the first connection without a close handshake.
+			return
+		}
+		<-ctx.Done()
+	})
+
+	states := make(chan FeedState, 4)
+	f.Reconnect = &ReconnectPolicy{MinBackoff: time.Millisecond}
+	f.OnStateChange = func(state FeedState, err error) {
+		states <- state
+	}
+
+	events := make(chan BookEvent)
+	go func() {
+		_ = f.BookEvents(ctx, "DUH", SnapshotEvent, events)
+	}()
+
+	ev := <-events
+	require.Equal(t, OrderBookBids{{41, 10}}, ev.Book.OrderBook().YesBids)
+
+	// The book is reloaded from the snapshot of the new subscription.
+	ev = <-events
+	require.Equal(t, SnapshotEvent, ev.Type)
+	require.Equal(t, OrderBookBids{{42, 10}}, ev.Book.OrderBook().YesBids)
+
+	require.Equal(t, FeedReconnecting, <-states)
+	require.Equal(t, FeedConnected, <-states)
+	require.Equal(t, FeedConnected, f.State())
+	require.NoError(t, f.Err())
+}
+
+func TestFeedSequenceGap(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Resubscribe", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// Books are resynced from the exchange rather than the REST API,
+		// since REST books carry no sequence number.
+		var requests atomic.Int64
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests.Add(1)
+		}))
+		defer srv.Close()
+
+		f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+			cmd := readCommand(ctx, t, c)
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(testSubscribed, cmd.ID, 1),
+				fmt.Sprintf(testSnapshot, 1, 40),
+				fmt.Sprintf(testDelta, 1, 3, 41),
+			)
+
+			cmd = readCommand(ctx, t, c)
+			assert.Equal(t, "unsubscribe", cmd.Command)
+
+			cmd = readCommand(ctx, t, c)
+			assert.Equal(t, "subscribe", cmd.Command)
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(testSubscribed, cmd.ID, 2),
+				fmt.Sprintf(testSnapshot, 2, 45),
+			)
+			<-ctx.Done()
+		})
+		f.Reconnect = &ReconnectPolicy{}
+		f.client = New(srv.URL + "/")
+
+		events := make(chan BookEvent)
+		go func() {
+			_ = f.BookEvents(ctx, "DUH", AllBookEvents, events)
+		}()
+
+		ev := <-events
+		require.Equal(t, SnapshotEvent, ev.Type)
+		require.Equal(t, OrderBookBids{{40, 10}}, ev.Book.OrderBook().YesBids)
+
+		// The gapped delta is never applied.
+		ev = <-events
+		require.Equal(t, SnapshotEvent, ev.Type)
+		require.Equal(t, OrderBookBids{{45, 10}}, ev.Book.OrderBook().YesBids)
+		require.Zero(t, requests.Load())
+	})
+}
+
+func TestFeedSubscribeReconnecting(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		conns atomic.Int64
+		drop  = make(chan struct{})
+	)
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		if conns.Add(1) == 1 {
+			<-drop
+			return
+		}
+		cmd := readCommand(ctx, t, c)
+		assert.Equal(t, "subscribe", cmd.Command)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 1),
+			fmt.Sprintf(testSnapshot, 1, 41),
+		)
+		<-ctx.Done()
+	})
+
+	// Reconnecting waits for release.
+	release := make(chan struct{})
+	states := make(chan FeedState, 4)
+	f.mu.Lock()
+	dial := f.dial
+	f.dial = func(ctx context.Context) (*websocket.Conn, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return dial(ctx)
+	}
+	f.Reconnect = &ReconnectPolicy{MinBackoff: time.Millisecond}
+	f.OnStateChange = func(state FeedState, err error) {
+		states <- state
+	}
+	f.mu.Unlock()
+
+	close(drop)
+	require.Equal(t, FeedReconnecting, <-states)
+
+	books := make(chan *StreamOrderBook, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- f.Book(ctx, "DUH", books)
+	}()
+
+	// The subscription waits for the connection instead of failing.
+	select {
+	case err := <-errs:
+		t.Fatalf("book: %v", err)
+	case <-time.After(time.Millisecond * 50):
+	}
+	close(release)
+	require.Equal(t, FeedConnected, <-states)
+	require.Equal(t, OrderBookBids{{41, 10}}, (<-books).YesBids)
+}
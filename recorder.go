@@ -0,0 +1,263 @@
+package kalshi
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JournalEntry is a raw message received by a Feed.
+type JournalEntry struct {
+	// ReceivedAt is the local time the message was read.
+	ReceivedAt time.Time `json:"received_at"`
+	Type       string    `json:"type"`
+	Sid        int       `json:"sid,omitempty"`
+	Seq        int       `json:"seq,omitempty"`
+	// Message is the message as sent by the exchange.
+	Message json.RawMessage `json:"message"`
+}
+
+// journalLine is the encoding of a JournalEntry. Messages that are not valid
+// JSON cannot be embedded, so they are kept in Raw instead.
+type journalLine struct {
+	JournalEntry
+	Raw []byte `json:"raw,omitempty"`
+}
+
+// Default rotation thresholds of a Recorder.
+const (
+	DefaultRecorderMaxBytes = 256 << 20
+	DefaultRecorderMaxAge   = time.Hour
+)
+
+// Recorder journals the raw messages of a Feed to gzip compressed JSON-lines
+// files. Set it as the Recorder of a Feed before the first subscription.
+//
+// Journals are named "<Prefix>-<time>.jsonl.gz" after the time of their
+// first entry in UTC, so they sort chronologically. A new journal is started
+// once the current one reaches MaxBytes of uncompressed entries or MaxAge.
+//
+// Recorder is safe for concurrent use. It keeps the first error it
+// encounters instead of failing the Feed; see Err.
+type Recorder struct {
+	// Dir is created if it does not exist.
+	Dir string
+	// Prefix defaults to "feed".
+	Prefix string
+	// MaxBytes defaults to DefaultRecorderMaxBytes.
+	MaxBytes int64
+	// MaxAge defaults to DefaultRecorderMaxAge.
+	MaxAge time.Duration
+
+	mu       sync.Mutex
+	f        *os.File
+	gz       *gzip.Writer
+	w        *bufio.Writer
+	size     int64
+	openedAt time.Time
+	err      error
+}
+
+func (r *Recorder) prefix() string {
+	if r.Prefix == "" {
+		return "feed"
+	}
+	return r.Prefix
+}
+
+// Record appends e to the current journal. Messages that are not valid JSON
+// are journaled too, and read back as they were recorded.
+func (r *Recorder) Record(e JournalEntry) error {
+	l := journalLine{JournalEntry: e}
+	if !json.Valid(e.Message) {
+		l.Message, l.Raw = nil, e.Message
+	}
+	line, err := json.Marshal(l)
+	if err != nil {
+		return fmt.Errorf("marshal entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+	err = r.record(e.ReceivedAt, line)
+	if err != nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *Recorder) record(at time.Time, line []byte) error {
+	maxBytes, maxAge := r.MaxBytes, r.MaxAge
+	if maxBytes <= 0 {
+		maxBytes = DefaultRecorderMaxBytes
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultRecorderMaxAge
+	}
+
+	if r.f != nil && (r.size >= maxBytes || at.Sub(r.openedAt) >= maxAge) {
+		err := r.closeJournal()
+		if err != nil {
+			return err
+		}
+	}
+	if r.f == nil {
+		err := r.openJournal(at)
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.w.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write %s: %w", r.f.Name(), err)
+	}
+	return nil
+}
+
+func (r *Recorder) openJournal(at time.Time) error {
+	err := os.MkdirAll(r.Dir, 0o755)
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(r.Dir, fmt.Sprintf("%s-%s.jsonl.gz",
+		r.prefix(), at.UTC().Format("20060102T150405.000000000Z"),
+	))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.gz = gzip.NewWriter(f)
+	r.w = bufio.NewWriter(r.gz)
+	r.size = 0
+	r.openedAt = at
+	return nil
+}
+
+func (r *Recorder) closeJournal() error {
+	if r.f == nil {
+		return nil
+	}
+	f, gz, w := r.f, r.gz, r.w
+	r.f, r.gz, r.w = nil, nil, nil
+
+	err := w.Flush()
+	if err == nil {
+		err = gz.Close()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("close %s: %w", f.Name(), err)
+	}
+	return nil
+}
+
+// Flush writes buffered entries through to the current journal. A journal is
+// only readable in full once it is closed, but flushed entries survive a
+// crash.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil || r.err != nil {
+		return r.err
+	}
+	err := r.w.Flush()
+	if err == nil {
+		err = r.gz.Flush()
+	}
+	if err != nil {
+		r.err = err
+	}
+	return err
+}
+
+// Err returns the first error encountered by the Recorder.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Close closes the current journal. Recording after Close starts a new one.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.closeJournal()
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// JournalFiles returns the journals with prefix in dir in chronological
+// order. An empty prefix selects the default prefix of Recorder.
+func JournalFiles(dir string, prefix string) ([]string, error) {
+	if prefix == "" {
+		prefix = "feed"
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix+"-") || !strings.HasSuffix(name, ".jsonl.gz") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// JournalReader reads the entries of a journal written by Recorder.
+type JournalReader struct {
+	gz *gzip.Reader
+	d  *json.Decoder
+}
+
+// NewJournalReader reads a journal from r.
+func NewJournalReader(r io.Reader) (*JournalReader, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("open gzip: %w", err)
+	}
+	return &JournalReader{gz: gz, d: json.NewDecoder(gz)}, nil
+}
+
+// Next returns the next entry, or io.EOF at the end of the journal. A journal
+// that was not closed ends with io.ErrUnexpectedEOF.
+func (r *JournalReader) Next() (JournalEntry, error) {
+	var l journalLine
+	err := r.d.Decode(&l)
+	if errors.Is(err, io.EOF) {
+		return l.JournalEntry, io.EOF
+	}
+	if err != nil {
+		return l.JournalEntry, fmt.Errorf("decode entry: %w", err)
+	}
+	if l.Raw != nil {
+		l.Message = l.Raw
+	}
+	return l.JournalEntry, nil
+}
@@ -0,0 +1,150 @@
+package kalshi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+// readJournals returns every entry of the journals in dir.
+func readJournals(t *testing.T, dir string) [][]JournalEntry {
+	t.Helper()
+
+	files, err := JournalFiles(dir, "")
+	require.NoError(t, err)
+
+	var journals [][]JournalEntry
+	for _, name := range files {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		defer f.Close()
+
+		r, err := NewJournalReader(f)
+		require.NoError(t, err)
+		var entries []JournalEntry
+		for {
+			e, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			require.NoError(t, err)
+			entries = append(entries, e)
+		}
+		journals = append(journals, entries)
+	}
+	return journals
+}
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "journal")
+	r := &Recorder{Dir: dir, MaxBytes: 200, MaxAge: time.Minute}
+
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	var want []JournalEntry
+	for i, offset := range []time.Duration{0, time.Second, time.Second * 2, time.Minute * 2} {
+		e := JournalEntry{
+			ReceivedAt: start.Add(offset),
+			Type:       "orderbook_delta",
+			Sid:        1,
+			Seq:        i + 1,
+			Message:    json.RawMessage(fmt.Sprintf(`{"type":"orderbook_delta","sid":1,"seq":%d}`, i+1)),
+		}
+		require.NoError(t, r.Record(e))
+		want = append(want, e)
+	}
+	require.NoError(t, r.Flush())
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Err())
+
+	files, err := JournalFiles(dir, "")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "feed-20230102T030405.000000000Z.jsonl.gz"),
+		filepath.Join(dir, "feed-20230102T030407.000000000Z.jsonl.gz"),
+		filepath.Join(dir, "feed-20230102T030605.000000000Z.jsonl.gz"),
+	}, files)
+
+	// The first journal rotates by size and the second by age.
+	require.Equal(t, [][]JournalEntry{want[:2], want[2:3], want[3:]}, readJournals(t, dir))
+}
+
+func TestFeedRecorder(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := readCommand(ctx, t, c)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 1),
+			fmt.Sprintf(testSnapshot, 1, 40),
+		)
+		<-ctx.Done()
+	})
+	dir := t.TempDir()
+	f.Recorder = &Recorder{Dir: dir}
+
+	books := make(chan *StreamOrderBook, 1)
+	go func() {
+		_ = f.Book(ctx, "DUH", books)
+	}()
+	<-books
+	require.NoError(t, f.Recorder.Close())
+
+	journals := readJournals(t, dir)
+	require.Len(t, journals, 1)
+	require.Len(t, journals[0], 2)
+
+	snapshot := journals[0][1]
+	require.Equal(t, "orderbook_snapshot", snapshot.Type)
+	require.Equal(t, 1, snapshot.Sid)
+	require.Equal(t, 1, snapshot.Seq)
+	require.WithinDuration(t, time.Now(), snapshot.ReceivedAt, time.Second)
+	require.JSONEq(t, fmt.Sprintf(testSnapshot, 1, 40), string(snapshot.Message))
+}
+
+func TestFeedRecorderUndecodable(t *testing.T) {
+	t.Parallel()
+
+	const broken = `{"type": "orderbook_snapshot", "sid": 1, "seq": tru`
+	ready := make(chan struct{})
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		<-ready
+		writeMessages(ctx, t, c, broken)
+		<-ctx.Done()
+	})
+	dir := t.TempDir()
+	f.Recorder = &Recorder{Dir: dir}
+	close(ready)
+
+	// The message ends the connection, but is journaled first.
+	require.Eventually(t, func() bool {
+		return f.Err() != nil
+	}, time.Second*5, time.Millisecond*5)
+	require.NoError(t, f.Recorder.Close())
+
+	journals := readJournals(t, dir)
+	require.Len(t, journals, 1)
+	require.Len(t, journals[0], 1)
+	e := journals[0][0]
+	require.Empty(t, e.Type)
+	require.Zero(t, e.Sid)
+	require.Equal(t, broken, string(e.Message))
+
+	// Messages that are not even JSON are kept byte for byte.
+	r := &Recorder{Dir: t.TempDir()}
+	require.NoError(t, r.Record(JournalEntry{ReceivedAt: time.Now(), Message: []byte("\xff")}))
+	require.NoError(t, r.Close())
+	require.Equal(t, "\xff", string(readJournals(t, r.Dir)[0][0].Message))
+}
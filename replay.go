@@ -0,0 +1,398 @@
+package kalshi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replayChannels maps the types of recorded messages to the channels that
+// send them.
+var replayChannels = map[string]string{
+	"orderbook_snapshot":  "orderbook_delta",
+	"orderbook_delta":     "orderbook_delta",
+	"ticker":              "ticker",
+	"trade":               "trade",
+	"fill":                "fill",
+	"user_order":          "user_orders",
+	"market_lifecycle_v2": "market_lifecycle_v2",
+}
+
+// Replayer replays journals written by Recorder through a Feed, so that code
+// written against a live Feed runs unchanged against recorded streams.
+//
+// Subscriptions on the Feed are served from the journals: each receives the
+// recorded messages of its channel and markets, renumbered into a sequence
+// of its own. Books start from the first recorded snapshot of their market,
+// or from a snapshot synthesized from the recorded stream if they subscribe
+// after it.
+//
+// Subscribe before calling Run, since subscriptions only receive messages
+// recorded after the replay position at the time they subscribe.
+type Replayer struct {
+	// Speed scales the pace of the recording. 1 replays in real time and 10
+	// replays ten times faster. Zero replays as fast as possible.
+	Speed float64
+
+	files []string
+	feed  *Feed
+	ctx   context.Context
+
+	mu      sync.Mutex
+	from    time.Time
+	now     time.Time
+	nextSid int
+	subs    map[int]*replaySubscription
+	// books tracks the recorded books to synthesize snapshots.
+	books map[string]*orderBookStreamState
+}
+
+type replaySubscription struct {
+	channel string
+	// marketTickers is empty for subscriptions to every market.
+	marketTickers map[string]bool
+	seq           int
+	// started holds the markets whose book was sent to the subscription.
+	started map[string]bool
+}
+
+func (sub *replaySubscription) follows(channel, marketTicker string) bool {
+	if sub.channel != channel {
+		return false
+	}
+	return len(sub.marketTickers) == 0 || sub.marketTickers[marketTicker]
+}
+
+func (sub *replaySubscription) update(action UpdateAction, marketTickers []string) {
+	for _, ticker := range marketTickers {
+		switch action {
+		case AddMarkets:
+			sub.marketTickers[ticker] = true
+		case DeleteMarkets:
+			delete(sub.marketTickers, ticker)
+			delete(sub.started, ticker)
+		}
+	}
+}
+
+// NewReplayer replays the journals in files in order. See JournalFiles.
+func NewReplayer(files ...string) *Replayer {
+	r := &Replayer{
+		files: files,
+		subs:  make(map[int]*replaySubscription),
+		books: make(map[string]*orderBookStreamState),
+	}
+	r.feed, r.ctx = makeFeed(nil, nil, nil)
+	r.feed.send = r.command
+	return r
+}
+
+// Feed returns the Feed that replays the journals. Its streams end with
+// io.EOF once the replay completes.
+func (r *Replayer) Feed() *Feed {
+	return r.feed
+}
+
+// Seek skips the messages recorded before t. Books are synthesized from the
+// skipped messages.
+func (r *Replayer) Seek(t time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.from = t
+}
+
+// Now returns the time the last replayed message was recorded.
+func (r *Replayer) Now() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.now
+}
+
+// command serves the commands of the Feed.
+func (r *Replayer) command(ctx context.Context, cmd command) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sid int
+	if len(cmd.Params.Sids) > 0 {
+		sid = cmd.Params.Sids[0]
+	}
+
+	switch cmd.Command {
+	case "subscribe":
+		if len(cmd.Params.Channels) != 1 {
+			r.feed.respond(cmd.ID, commandResponse{}, &FeedError{
+				Message: fmt.Sprintf("replay requires one channel, got %v", cmd.Params.Channels),
+			})
+			return nil
+		}
+		sub := &replaySubscription{
+			channel:       cmd.Params.Channels[0],
+			marketTickers: make(map[string]bool),
+			started:       make(map[string]bool),
+		}
+		sub.update(AddMarkets, cmd.Params.marketTickers())
+		r.nextSid++
+		r.subs[r.nextSid] = sub
+		r.feed.respond(cmd.ID, commandResponse{ID: cmd.ID, Type: "subscribed", Sid: r.nextSid}, nil)
+	case "unsubscribe":
+		for _, sid := range cmd.Params.Sids {
+			delete(r.subs, sid)
+		}
+		r.feed.respond(cmd.ID, commandResponse{ID: cmd.ID, Type: "unsubscribed", Sid: sid}, nil)
+	case "update_subscription":
+		sub, ok := r.subs[sid]
+		if !ok {
+			r.feed.respond(cmd.ID, commandResponse{}, &FeedError{
+				Message: fmt.Sprintf("unknown subscription %v", sid),
+			})
+			return nil
+		}
+		sub.update(UpdateAction(cmd.Params.Action), cmd.Params.MarketTickers)
+		r.feed.respond(cmd.ID, commandResponse{ID: cmd.ID, Type: "ok", Sid: sid}, nil)
+	default:
+		return fmt.Errorf("replay does not support %q", cmd.Command)
+	}
+	return nil
+}
+
+// Run replays the journals until they end, ctx is done or the Feed is
+// closed. A Replayer can only be run once.
+func (r *Replayer) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-r.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	err := r.run(ctx)
+	if err == nil {
+		err = io.EOF
+	}
+	r.feed.fail(err)
+	close(r.feed.done)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (r *Replayer) run(ctx context.Context) error {
+	var p replayPacer
+	for _, name := range r.files {
+		err := r.replayFile(ctx, name, &p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replayer) replayFile(ctx context.Context, name string, p *replayPacer) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	jr, err := NewJournalReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for {
+		e, err := jr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		err = r.replay(ctx, e, p)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// replayMessage holds the fields of recorded messages needed to route them.
+type replayMessage struct {
+	Msg struct {
+		MarketID     string `json:"market_id"`
+		MarketTicker string `json:"market_ticker"`
+		Ticker       string `json:"ticker"`
+	} `json:"msg"`
+}
+
+func (m replayMessage) marketTicker() string {
+	switch {
+	case m.Msg.MarketTicker != "":
+		return m.Msg.MarketTicker
+	case m.Msg.MarketID != "":
+		return m.Msg.MarketID
+	default:
+		return m.Msg.Ticker
+	}
+}
+
+// replay delivers e to every subscription that follows its market.
+func (r *Replayer) replay(ctx context.Context, e JournalEntry, p *replayPacer) error {
+	channel, ok := replayChannels[e.Type]
+	if !ok {
+		// Responses to the recorded commands.
+		return nil
+	}
+	var m replayMessage
+	err := json.Unmarshal(e.Message, &m)
+	if err != nil {
+		return fmt.Errorf("unmarshal %s: %w", e.Type, err)
+	}
+	marketTicker := m.marketTicker()
+
+	r.mu.Lock()
+	err = r.track(e, marketTicker)
+	skip := e.ReceivedAt.Before(r.from)
+	r.mu.Unlock()
+	if err != nil || skip {
+		return err
+	}
+
+	err = p.wait(ctx, r.Speed, e.ReceivedAt)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = e.ReceivedAt
+
+	sids := make([]int, 0, len(r.subs))
+	for sid, sub := range r.subs {
+		if sub.follows(channel, marketTicker) {
+			sids = append(sids, sid)
+		}
+	}
+	sort.Ints(sids)
+
+	for _, sid := range sids {
+		sub := r.subs[sid]
+		header := subscriptionMessageHeader{Type: e.Type, Sid: sid}
+		message := []byte(e.Message)
+
+		if channel == "orderbook_delta" && !sub.started[marketTicker] {
+			if e.Type == "orderbook_delta" {
+				book, ok := r.books[marketTicker]
+				if !ok {
+					// The recording holds no snapshot yet.
+					continue
+				}
+				header.Type = "orderbook_snapshot"
+				message, err = snapshotMessage(book)
+				if err != nil {
+					return err
+				}
+			}
+			sub.started[marketTicker] = true
+		}
+
+		_, msg, err := decodeMessage(message)
+		if err != nil {
+			return err
+		}
+		sub.seq++
+		header.Seq = sub.seq
+		err = r.feed.dispatch(header, message, msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// track follows the recorded books. r.mu must be held.
+func (r *Replayer) track(e JournalEntry, marketTicker string) error {
+	switch e.Type {
+	case "orderbook_snapshot":
+		var snapshot orderBookSnapshot
+		err := json.Unmarshal(e.Message, &snapshot)
+		if err != nil {
+			return fmt.Errorf("unmarshal snapshot: %w", err)
+		}
+		book := makeOrderBookStreamState(marketTicker)
+		err = book.LoadBook(OrderBook{YesBids: snapshot.Msg.Yes, NoBids: snapshot.Msg.No})
+		if err != nil {
+			delete(r.books, marketTicker)
+			return nil
+		}
+		r.books[marketTicker] = &book
+	case "orderbook_delta":
+		book, ok := r.books[marketTicker]
+		if !ok {
+			return nil
+		}
+		var delta orderBookDelta
+		err := json.Unmarshal(e.Message, &delta)
+		if err != nil {
+			return fmt.Errorf("unmarshal delta: %w", err)
+		}
+		err = book.ApplyDelta(delta.Msg.Side, delta.Msg.Price, delta.Msg.Delta)
+		if err != nil {
+			// The recording is broken until the next snapshot.
+			delete(r.books, marketTicker)
+		}
+	}
+	return nil
+}
+
+// snapshotMessage returns a snapshot message of book.
+func snapshotMessage(book *orderBookStreamState) ([]byte, error) {
+	var m orderBookSnapshot
+	m.Type = "orderbook_snapshot"
+	m.Msg.MarketTicker = book.MarketID
+	m.Msg.Yes = book.Yes.bids()
+	m.Msg.No = book.No.bids()
+	return json.Marshal(m)
+}
+
+// replayPacer spaces out replayed messages as they were recorded.
+type replayPacer struct {
+	start  time.Time
+	origin time.Time
+}
+
+// wait blocks until the message recorded at is due.
+func (p *replayPacer) wait(ctx context.Context, speed float64, at time.Time) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if speed <= 0 {
+		return nil
+	}
+	if p.origin.IsZero() {
+		p.start, p.origin = time.Now(), at
+		return nil
+	}
+
+	due := p.start.Add(time.Duration(float64(at.Sub(p.origin)) / speed))
+	d := time.Until(due)
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,160 @@
+package kalshi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testJournal records a session with a book and a trade stream and returns
+// the journal files and the time of the first entry.
+func testJournal(t *testing.T) ([]string, time.Time) {
+	t.Helper()
+
+	dir := t.TempDir()
+	rec := &Recorder{Dir: dir}
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	msgs := []string{
+		fmt.Sprintf(testSubscribed, 1, 1),
+		fmt.Sprintf(testSnapshot, 1, 40),
+		`{"type": "orderbook_snapshot", "sid": 1, "seq": 2, "msg": {"market_ticker": "OTHER", "yes": [[10, 1]], "no": []}}`,
+		fmt.Sprintf(testDelta, 1, 3, 41),
+		`{"type": "trade", "sid": 2, "seq": 1, "msg": {"market_ticker": "DUH", "yes_price": 41, "no_price": 59, "count": 3, "taker_side": "yes", "ts": 1672628645}}`,
+		fmt.Sprintf(testDelta, 1, 4, 42),
+		fmt.Sprintf(testDelta, 1, 5, 43),
+	}
+	for i, msg := range msgs {
+		var header subscriptionMessageHeader
+		require.NoError(t, json.Unmarshal([]byte(msg), &header))
+		require.NoError(t, rec.Record(JournalEntry{
+			ReceivedAt: start.Add(time.Duration(i) * time.Millisecond * 20),
+			Type:       header.Type,
+			Sid:        header.Sid,
+			Seq:        header.Seq,
+			Message:    json.RawMessage(msg),
+		}))
+	}
+	require.NoError(t, rec.Close())
+
+	files, err := JournalFiles(dir, "")
+	require.NoError(t, err)
+	return files, start
+}
+
+// waitSubscriptions waits until f has n subscriptions.
+func waitSubscriptions(t *testing.T, f *Feed, n int) {
+	require.Eventually(t, func() bool {
+		return len(f.Subscriptions()) == n
+	}, time.Second*5, time.Millisecond)
+}
+
+func TestReplayer(t *testing.T) {
+	t.Parallel()
+
+	t.Run("AsFastAsPossible", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		files, start := testJournal(t)
+
+		r := NewReplayer(files...)
+		f := r.Feed()
+
+		books := make(chan *StreamOrderBook, 10)
+		bookErr := make(chan error, 1)
+		go func() {
+			bookErr <- f.Book(ctx, "DUH", books)
+		}()
+		trades := make(chan Trade, 10)
+		tradeErr := make(chan error, 1)
+		go func() {
+			tradeErr <- f.Trades(ctx, nil, trades)
+		}()
+		waitSubscriptions(t, f, 2)
+
+		require.NoError(t, r.Run(ctx))
+		require.ErrorIs(t, <-bookErr, io.EOF)
+		require.ErrorIs(t, <-tradeErr, io.EOF)
+		require.Equal(t, start.Add(time.Millisecond*120), r.Now())
+
+		// The book of another market is not delivered.
+		require.Len(t, books, 4)
+		var last *StreamOrderBook
+		for len(books) > 0 {
+			last = <-books
+		}
+		require.Equal(t, OrderBookBids{{40, 10}, {41, 5}, {42, 5}, {43, 5}}, last.YesBids)
+
+		require.Len(t, trades, 1)
+		trade := <-trades
+		require.Equal(t, "DUH", trade.Ticker)
+		require.Equal(t, 3, trade.Count)
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		files, start := testJournal(t)
+
+		r := NewReplayer(files...)
+		r.Seek(start.Add(time.Millisecond * 100))
+		f := r.Feed()
+
+		events := make(chan BookEvent, 10)
+		go func() {
+			_ = f.BookEvents(ctx, "DUH", SnapshotEvent|LevelChangedEvent, events)
+		}()
+		waitSubscriptions(t, f, 1)
+		require.NoError(t, r.Run(ctx))
+
+		// The book starts from the skipped messages.
+		ev := <-events
+		require.Equal(t, SnapshotEvent, ev.Type)
+		require.Equal(t, 1, ev.Seq)
+		require.Len(t, events, 1)
+		ev = <-events
+		require.Equal(t, LevelChangedEvent, ev.Type)
+		require.Equal(t, OrderBookBids{{40, 10}, {41, 5}, {42, 5}, {43, 5}}, ev.Book.OrderBook().YesBids)
+	})
+
+	t.Run("Speed", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+		files, _ := testJournal(t)
+
+		r := NewReplayer(files...)
+		r.Speed = 2
+		f := r.Feed()
+
+		books := make(chan *StreamOrderBook, 10)
+		go func() {
+			_ = f.Book(ctx, "DUH", books)
+		}()
+		waitSubscriptions(t, f, 1)
+
+		// The data of the recording spans 100ms.
+		began := time.Now()
+		require.NoError(t, r.Run(ctx))
+		require.GreaterOrEqual(t, time.Since(began), time.Millisecond*50)
+		require.Len(t, books, 4)
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		t.Parallel()
+		files, _ := testJournal(t)
+
+		r := NewReplayer(files...)
+		r.Speed = 0.001
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(time.Millisecond * 20)
+			cancel()
+		}()
+		require.ErrorIs(t, r.Run(ctx), context.Canceled)
+		require.ErrorIs(t, r.Feed().Err(), context.Canceled)
+	})
+}
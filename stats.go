@@ -0,0 +1,208 @@
+package kalshi
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Histogram is a snapshot of a distribution of durations.
+type Histogram struct {
+	// Bounds are the inclusive upper bounds of the buckets in ascending
+	// order.
+	Bounds []time.Duration
+	// Counts holds the observations of each bucket, followed by those above
+	// the last bound.
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Mean returns the mean observation.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the upper bound of the bucket holding the q quantile, or
+// -1 if it lies above the last bound.
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(h.Count))
+	var seen uint64
+	for i, n := range h.Counts {
+		seen += n
+		if seen > rank || seen == h.Count {
+			if i == len(h.Bounds) {
+				return -1
+			}
+			return h.Bounds[i]
+		}
+	}
+	return -1
+}
+
+var (
+	decodeBounds = []time.Duration{
+		time.Microsecond, time.Microsecond * 5, time.Microsecond * 10,
+		time.Microsecond * 25, time.Microsecond * 50, time.Microsecond * 100,
+		time.Microsecond * 250, time.Microsecond * 500, time.Millisecond,
+		time.Millisecond * 10,
+	}
+	latencyBounds = []time.Duration{
+		time.Millisecond * 10, time.Millisecond * 50, time.Millisecond * 100,
+		time.Millisecond * 250, time.Millisecond * 500, time.Second,
+		time.Second * 2, time.Second * 5, time.Second * 10, time.Second * 30,
+	}
+)
+
+// histogram is the live form of Histogram.
+type histogram struct {
+	bounds []time.Duration
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)+1),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := sort.Search(len(h.bounds), func(i int) bool {
+		return d <= h.bounds[i]
+	})
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: h.bounds,
+		Counts: make([]uint64, len(h.counts)),
+		Count:  h.count.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+	}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+	}
+	return s
+}
+
+// streamStats counts the messages of a stream across the subscriptions that
+// serve it.
+type streamStats struct {
+	// messages and bytes are guarded by the mutex of the Feed.
+	messages map[string]uint64
+	bytes    uint64
+
+	gaps    atomic.Uint64
+	decode  *histogram
+	latency *histogram
+}
+
+func newStreamStats() *streamStats {
+	return &streamStats{
+		messages: make(map[string]uint64),
+		decode:   newHistogram(decodeBounds),
+		latency:  newHistogram(latencyBounds),
+	}
+}
+
+// decoded records the decoding of a message that began at start.
+func (st *streamStats) decoded(start time.Time) {
+	st.decode.observe(time.Since(start))
+}
+
+// exchangeTimer is implemented by messages that carry the time the exchange
+// sent them.
+type exchangeTimer interface {
+	exchangeTime() time.Time
+}
+
+func (t Ticker) exchangeTime() time.Time {
+	return t.Ts.Time()
+}
+
+func (m tradeMessage) exchangeTime() time.Time {
+	return m.Ts.Time()
+}
+
+func (m fillMessage) exchangeTime() time.Time {
+	return m.Ts.Time()
+}
+
+// SubscriptionStats counts the messages of a subscription. Counts carry over
+// when a subscription is replaced after a reconnect or resync.
+type SubscriptionStats struct {
+	Subscription
+	// Messages counts messages by type.
+	Messages     map[string]uint64
+	Bytes        uint64
+	SequenceGaps uint64
+	// DecodeTime is the time spent decoding message payloads.
+	DecodeTime Histogram
+	// Latency is the time from the exchange timestamp of a message to its
+	// decoding, for channels that send timestamps. Exchange timestamps only
+	// have a resolution of seconds.
+	Latency Histogram
+}
+
+// FeedStats is a snapshot of the health of a Feed.
+type FeedStats struct {
+	State FeedState
+	// Messages counts every message read by type.
+	Messages     map[string]uint64
+	Bytes        uint64
+	Reconnects   uint64
+	SequenceGaps uint64
+	Delivery     DeliveryStats
+	// Subscriptions are ordered by sid.
+	Subscriptions []SubscriptionStats
+}
+
+// Stats returns a snapshot of the counters of the Feed.
+func (s *Feed) Stats() FeedStats {
+	st := FeedStats{
+		Reconnects:   s.reconnectCount.Load(),
+		SequenceGaps: s.gaps.Load(),
+		Delivery:     s.DeliveryStats(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.State = s.state
+	st.Bytes = s.bytes
+	st.Messages = make(map[string]uint64, len(s.messages))
+	for typ, n := range s.messages {
+		st.Messages[typ] = n
+	}
+
+	for sid, sub := range s.subs {
+		ss := SubscriptionStats{
+			Subscription: sub.info(sid),
+			Messages:     make(map[string]uint64, len(sub.stats.messages)),
+			Bytes:        sub.stats.bytes,
+			SequenceGaps: sub.stats.gaps.Load(),
+			DecodeTime:   sub.stats.decode.snapshot(),
+			Latency:      sub.stats.latency.snapshot(),
+		}
+		for typ, n := range sub.stats.messages {
+			ss.Messages[typ] = n
+		}
+		st.Subscriptions = append(st.Subscriptions, ss)
+	}
+	sort.Slice(st.Subscriptions, func(i, j int) bool {
+		return st.Subscriptions[i].Sid < st.Subscriptions[j].Sid
+	})
+	return st
+}
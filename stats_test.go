@@ -0,0 +1,90 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestHistogram(t *testing.T) {
+	t.Parallel()
+
+	h := newHistogram([]time.Duration{time.Millisecond, time.Millisecond * 10})
+	for _, d := range []time.Duration{
+		time.Microsecond, time.Millisecond, time.Millisecond * 2, time.Millisecond * 3, time.Second,
+	} {
+		h.observe(d)
+	}
+
+	s := h.snapshot()
+	require.Equal(t, []uint64{2, 2, 1}, s.Counts)
+	require.Equal(t, uint64(5), s.Count)
+	require.Equal(t, time.Millisecond*1006+time.Microsecond, s.Sum)
+	require.Equal(t, s.Sum/5, s.Mean())
+	require.Equal(t, time.Millisecond, s.Quantile(0.2))
+	require.Equal(t, time.Millisecond*10, s.Quantile(0.5))
+	require.Equal(t, time.Duration(-1), s.Quantile(0.99))
+	require.Equal(t, time.Duration(0), Histogram{}.Quantile(0.5))
+}
+
+func TestFeedStats(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := readCommand(ctx, t, c)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 1),
+			fmt.Sprintf(testSnapshot, 1, 40),
+			fmt.Sprintf(testDelta, 1, 2, 41),
+			fmt.Sprintf(testDelta, 1, 4, 42),
+		)
+
+		readCommand(ctx, t, c) // unsubscribe
+		cmd = readCommand(ctx, t, c)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 2),
+			fmt.Sprintf(testSnapshot, 2, 40),
+		)
+		<-ctx.Done()
+	})
+	f.Reconnect = &ReconnectPolicy{}
+
+	books := make(chan *StreamOrderBook, 3)
+	go func() {
+		_ = f.Book(ctx, "DUH", books)
+	}()
+	for i := 0; i < 3; i++ {
+		<-books
+	}
+
+	st := f.Stats()
+	require.Equal(t, FeedConnected, st.State)
+	require.Equal(t, map[string]uint64{
+		"subscribed":         2,
+		"orderbook_snapshot": 2,
+		"orderbook_delta":    2,
+	}, st.Messages)
+	require.NotZero(t, st.Bytes)
+	require.Equal(t, uint64(1), st.SequenceGaps)
+	require.Equal(t, uint64(0), st.Reconnects)
+
+	// Counts carry over to the subscription that replaced the gapped one.
+	require.Len(t, st.Subscriptions, 1)
+	sub := st.Subscriptions[0]
+	require.Equal(t, 2, sub.Sid)
+	require.Equal(t, "orderbook_delta", sub.Channel)
+	require.Equal(t, map[string]uint64{
+		"orderbook_snapshot": 2,
+		"orderbook_delta":    2,
+	}, sub.Messages)
+	require.Equal(t, uint64(1), sub.SequenceGaps)
+	// The gapped delta is not decoded.
+	require.Equal(t, uint64(3), sub.DecodeTime.Count)
+	require.Zero(t, sub.Latency.Count)
+}
@@ -0,0 +1,263 @@
+package kalshi
+
+import "sync"
+
+// BookEventType identifies the kind of a BookEvent. Types are bit flags so
+// they may be combined into a mask for BookEvents.
+type BookEventType int
+
+const (
+	// SnapshotEvent is sent when the entire book is replaced. Changes to the
+	// top of the book caused by a snapshot are not reported separately.
+	SnapshotEvent BookEventType = 1 << iota
+	// LevelChangedEvent is sent for every delta.
+	LevelChangedEvent
+	// BestBidChangedEvent is sent when the price or quantity of the best Yes
+	// bid changes.
+	BestBidChangedEvent
+	// BestAskChangedEvent is sent when the price or quantity of the best Yes
+	// ask, which is implied by the best No bid, changes.
+	BestAskChangedEvent
+
+	AllBookEvents = SnapshotEvent | LevelChangedEvent | BestBidChangedEvent | BestAskChangedEvent
+)
+
+func (t BookEventType) String() string {
+	switch t {
+	case SnapshotEvent:
+		return "snapshot"
+	case LevelChangedEvent:
+		return "level_changed"
+	case BestBidChangedEvent:
+		return "best_bid_changed"
+	case BestAskChangedEvent:
+		return "best_ask_changed"
+	default:
+		return "unknown"
+	}
+}
+
+// BookDelta is a change in the resting quantity at a single price level, as
+// sent by the orderbook_delta channel.
+type BookDelta struct {
+	Side  Side
+	Price Cents
+	Delta int
+}
+
+// BookEvent is sent by BookEvents.
+type BookEvent struct {
+	Type     BookEventType
+	MarketID string
+	// Seq is the sequence number of the message that caused the event.
+	Seq int
+	// Delta is the delta that caused the event. It is zero for
+	// SnapshotEvent.
+	Delta BookDelta
+	// Price and Quantity describe the level after the event was applied.
+	//
+	// For LevelChangedEvent, they describe the level that Delta touched. For
+	// BestBidChangedEvent and BestAskChangedEvent, they describe the new best
+	// Yes bid or ask respectively, and are zero when that side is empty.
+	Price    Cents
+	Quantity int
+	// Book is the full book the event was applied to.
+	Book *StreamBook
+}
+
+// StreamBook is a streamed order book that is safe for concurrent reads. It
+// always reflects the most recently applied message, which may be newer than
+// the BookEvent that references it. Use Seq to tell the two apart.
+type StreamBook struct {
+	// marketID never changes, so it can be read without mu.
+	marketID string
+
+	mu    sync.RWMutex
+	state orderBookStreamState
+	seq   int
+	// watch, if set, records the changes to the book while it is verified.
+	watch *bookWatch
+}
+
+func newStreamBook(marketID string) *StreamBook {
+	return &StreamBook{
+		marketID: marketID,
+		state:    makeOrderBookStreamState(marketID),
+	}
+}
+
+// reset empties the book.
+func (b *StreamBook) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = makeOrderBookStreamState(b.marketID)
+	b.seq = 0
+	if b.watch != nil {
+		b.watch.reloaded = true
+	}
+}
+
+// MarketID returns the ticker of the market the book belongs to.
+func (b *StreamBook) MarketID() string {
+	return b.marketID
+}
+
+// Seq returns the sequence number of the last message applied to the book.
+func (b *StreamBook) Seq() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.seq
+}
+
+// OrderBook returns a copy of the book.
+func (b *StreamBook) OrderBook() *StreamOrderBook {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.state.OrderBook()
+}
+
+// CopyOrderBook writes the book into dst, reusing its capacity.
+func (b *StreamBook) CopyOrderBook(dst *StreamOrderBook) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	b.state.CopyOrderBook(dst)
+}
+
+// BestYesBid returns the highest Yes bid.
+func (b *StreamBook) BestYesBid() (OrderBookBid, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.state.BestYesBid()
+}
+
+// BestNoBid returns the highest No bid.
+func (b *StreamBook) BestNoBid() (OrderBookBid, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.state.BestNoBid()
+}
+
+// CheckIntegrity returns an *IntegrityError if the book is crossed or
+// locked.
+func (b *StreamBook) CheckIntegrity() error {
+	if err := b.checkIntegrity(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (b *StreamBook) checkIntegrity() *IntegrityError {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.state.checkIntegrity()
+}
+
+// bestAsk returns the best Yes ask implied by the No bids in Yes terms.
+func (b *StreamBook) bestAsk() (Cents, int) {
+	bid, ok := b.state.BestNoBid()
+	if !ok {
+		return 0, 0
+	}
+	return 100 - bid.Price, bid.Quantity
+}
+
+func (b *StreamBook) loadSnapshot(seq int, book OrderBook, emit func(BookEvent)) error {
+	b.mu.Lock()
+	err := b.state.LoadBook(book)
+	b.seq = seq
+	if b.watch != nil {
+		b.watch.reloaded = true
+	}
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	emit(BookEvent{
+		Type:     SnapshotEvent,
+		MarketID: b.state.MarketID,
+		Seq:      seq,
+		Book:     b,
+	})
+	return nil
+}
+
+// integrityCheck is how applyDelta checks the integrity of a book.
+type integrityCheck int
+
+const (
+	noCheck integrityCheck = iota
+	// reportCheck keeps a delta that fails the check.
+	reportCheck
+	// rejectCheck rolls back a delta that fails the check before it becomes
+	// visible to readers of the book.
+	rejectCheck
+)
+
+// applyDelta applies delta to the book and emits its events. Unless check
+// is noCheck, the updated book is checked under the same lock and the
+// failure, if any, is returned. A delta rejected by rejectCheck leaves the
+// book unchanged and emits nothing. Only deltas that break a consistent
+// book fail the check, so that a book admitted crossed, such as a crossed
+// snapshot loaded after repeated resyncs, keeps receiving deltas.
+func (b *StreamBook) applyDelta(seq int, delta BookDelta, check integrityCheck, emit func(BookEvent)) (*IntegrityError, error) {
+	b.mu.Lock()
+	prevBid, _ := b.state.BestYesBid()
+	prevAskPrice, prevAskQuantity := b.bestAsk()
+	if check != noCheck && b.state.checkIntegrity() != nil {
+		check = noCheck
+	}
+
+	err := b.state.ApplyDelta(delta.Side, delta.Price, delta.Delta)
+	if err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	var ierr *IntegrityError
+	if check != noCheck {
+		ierr = b.state.checkIntegrity()
+	}
+	if ierr != nil && check == rejectCheck {
+		// Reverting a valid delta cannot fail.
+		_ = b.state.ApplyDelta(delta.Side, delta.Price, -delta.Delta)
+		b.mu.Unlock()
+		return ierr, nil
+	}
+	b.seq = seq
+	if b.watch != nil {
+		b.watch.touch(delta.Side, delta.Price)
+	}
+
+	var quantity int
+	if delta.Side == Yes {
+		quantity = b.state.Yes.quantity[delta.Price]
+	} else {
+		quantity = b.state.No.quantity[delta.Price]
+	}
+	bid, _ := b.state.BestYesBid()
+	askPrice, askQuantity := b.bestAsk()
+	b.mu.Unlock()
+
+	ev := BookEvent{
+		Type:     LevelChangedEvent,
+		MarketID: b.state.MarketID,
+		Seq:      seq,
+		Delta:    delta,
+		Price:    delta.Price,
+		Quantity: quantity,
+		Book:     b,
+	}
+	emit(ev)
+
+	if bid != prevBid {
+		ev.Type = BestBidChangedEvent
+		ev.Price, ev.Quantity = bid.Price, bid.Quantity
+		emit(ev)
+	}
+	if askPrice != prevAskPrice || askQuantity != prevAskQuantity {
+		ev.Type = BestAskChangedEvent
+		ev.Price, ev.Quantity = askPrice, askQuantity
+		emit(ev)
+	}
+	return ierr, nil
+}
@@ -0,0 +1,121 @@
+package kalshi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamBookEvents(t *testing.T) {
+	t.Parallel()
+
+	book := newStreamBook("duh")
+
+	var events []BookEvent
+	collect := func(ev BookEvent) {
+		events = append(events, ev)
+	}
+	requireTypes := func(want ...BookEventType) {
+		t.Helper()
+		var got []BookEventType
+		for _, ev := range events {
+			got = append(got, ev.Type)
+		}
+		require.Equal(t, want, got)
+		events = events[:0]
+	}
+
+	err := book.loadSnapshot(1, OrderBook{
+		YesBids: OrderBookBids{{40, 10}},
+		NoBids:  OrderBookBids{{50, 5}},
+	}, collect)
+	require.NoError(t, err)
+	require.Equal(t, SnapshotEvent, events[0].Type)
+	require.Equal(t, 1, events[0].Seq)
+	require.Same(t, book, events[0].Book)
+	requireTypes(SnapshotEvent)
+
+	// A level away from the top only changes the level.
+	_, err = book.applyDelta(2, BookDelta{Side: Yes, Price: 30, Delta: 3}, noCheck, collect)
+	require.NoError(t, err)
+	require.Equal(t, BookEvent{
+		Type:     LevelChangedEvent,
+		MarketID: "duh",
+		Seq:      2,
+		Delta:    BookDelta{Side: Yes, Price: 30, Delta: 3},
+		Price:    30,
+		Quantity: 3,
+		Book:     book,
+	}, events[0])
+	requireTypes(LevelChangedEvent)
+
+	// Quantity at the top changes the best bid.
+	_, err = book.applyDelta(3, BookDelta{Side: Yes, Price: 40, Delta: -4}, noCheck, collect)
+	require.NoError(t, err)
+	require.Equal(t, Cents(40), events[1].Price)
+	require.Equal(t, 6, events[1].Quantity)
+	requireTypes(LevelChangedEvent, BestBidChangedEvent)
+
+	// A better No bid lowers the Yes ask.
+	_, err = book.applyDelta(4, BookDelta{Side: No, Price: 55, Delta: 1}, noCheck, collect)
+	require.NoError(t, err)
+	require.Equal(t, Cents(45), events[1].Price)
+	require.Equal(t, 1, events[1].Quantity)
+	requireTypes(LevelChangedEvent, BestAskChangedEvent)
+
+	// Emptying a side reports an empty top.
+	_, err = book.applyDelta(5, BookDelta{Side: No, Price: 55, Delta: -1}, noCheck, collect)
+	require.NoError(t, err)
+	_, err = book.applyDelta(6, BookDelta{Side: No, Price: 50, Delta: -5}, noCheck, collect)
+	require.NoError(t, err)
+	require.Zero(t, events[3].Price)
+	require.Zero(t, events[3].Quantity)
+	requireTypes(LevelChangedEvent, BestAskChangedEvent, LevelChangedEvent, BestAskChangedEvent)
+
+	// Invalid deltas don't emit.
+	_, err = book.applyDelta(7, BookDelta{Side: No, Price: 50, Delta: -5}, noCheck, collect)
+	require.Error(t, err)
+	requireTypes()
+
+	require.Equal(t, 6, book.Seq())
+	require.Equal(t, OrderBookBids{{30, 3}, {40, 6}}, book.OrderBook().YesBids)
+}
+
+func TestStreamBookIntegrityCheck(t *testing.T) {
+	t.Parallel()
+
+	book := newStreamBook("duh")
+	var events []BookEvent
+	collect := func(ev BookEvent) {
+		events = append(events, ev)
+	}
+	require.NoError(t, book.loadSnapshot(1, OrderBook{
+		YesBids: OrderBookBids{{40, 10}},
+		NoBids:  OrderBookBids{{50, 5}},
+	}, collect))
+	events = events[:0]
+
+	crossing := BookDelta{Side: Yes, Price: 70, Delta: 1}
+
+	// A rejected delta is never visible.
+	ierr, err := book.applyDelta(2, crossing, rejectCheck, collect)
+	require.NoError(t, err)
+	require.Equal(t, CrossedBook, ierr.Problem)
+	require.Empty(t, events)
+	require.Equal(t, 1, book.Seq())
+	require.Equal(t, OrderBookBids{{40, 10}}, book.OrderBook().YesBids)
+
+	// A reported delta is applied.
+	ierr, err = book.applyDelta(2, crossing, reportCheck, collect)
+	require.NoError(t, err)
+	require.Equal(t, CrossedBook, ierr.Problem)
+	require.Equal(t, 2, book.Seq())
+	require.Equal(t, OrderBookBids{{40, 10}, {70, 1}}, book.OrderBook().YesBids)
+	require.NotEmpty(t, events)
+
+	// Deltas to a book that is already crossed are not blamed for it.
+	ierr, err = book.applyDelta(3, BookDelta{Side: Yes, Price: 10, Delta: 5}, rejectCheck, collect)
+	require.NoError(t, err)
+	require.Nil(t, ierr)
+	require.Equal(t, 3, book.Seq())
+}
@@ -0,0 +1,124 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Subscription describes an active subscription of a Feed.
+type Subscription struct {
+	// Sid identifies the subscription on the current connection. It changes
+	// when the Feed reconnects.
+	Sid           int
+	Channel       string
+	MarketTickers []string
+	// LastMessage is when the last message of the subscription was read,
+	// or when it was acknowledged if none was.
+	LastMessage time.Time
+}
+
+// Subscriptions returns the acknowledged subscriptions of the Feed by
+// ascending sid. Subscriptions waiting to be restored after a reconnect are
+// omitted.
+func (s *Feed) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subs := make([]Subscription, 0, len(s.subs))
+	for sid, sub := range s.subs {
+		subs = append(subs, sub.info(sid))
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Sid < subs[j].Sid
+	})
+	return subs
+}
+
+// info describes sub. The mutex of the Feed must be held.
+func (sub *subscription) info(sid int) Subscription {
+	var channel string
+	if len(sub.params.Channels) > 0 {
+		channel = sub.params.Channels[0]
+	}
+	return Subscription{
+		Sid:           sid,
+		Channel:       channel,
+		MarketTickers: append([]string(nil), sub.params.marketTickers()...),
+		LastMessage:   sub.lastMessage,
+	}
+}
+
+// Unsubscribe ends the subscription sid and waits for the exchange to
+// acknowledge it. The method streaming the subscription returns nil.
+// Messages of the subscription stop being delivered even if Unsubscribe
+// fails.
+func (s *Feed) Unsubscribe(ctx context.Context, sid int) error {
+	s.mu.Lock()
+	sub, ok := s.subs[sid]
+	if !ok {
+		s.mu.Unlock()
+		return fmt.Errorf("unknown subscription %v", sid)
+	}
+	sub.dropped = true
+	s.mu.Unlock()
+
+	s.endSubscription(sub, nil)
+
+	r, err := s.command(ctx, command{
+		Command: "unsubscribe",
+		Params:  commandParams{Sids: []int{sid}},
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if r.Type != "unsubscribed" {
+		return fmt.Errorf("unexpected message: %+v", r)
+	}
+	return nil
+}
+
+// UpdateAction is an update to the market tickers of a subscription.
+type UpdateAction string
+
+const (
+	AddMarkets    UpdateAction = "add_markets"
+	DeleteMarkets UpdateAction = "delete_markets"
+)
+
+// UpdateSubscription adds or removes market tickers from the subscription
+// sid and waits for the exchange to acknowledge it. Added markets start
+// with a snapshot on channels that send one. The update is kept if the
+// subscription is restored after a reconnect or resync.
+func (s *Feed) UpdateSubscription(ctx context.Context, sid int, action UpdateAction, marketTickers []string) error {
+	switch action {
+	case AddMarkets, DeleteMarkets:
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+	if len(marketTickers) == 0 {
+		return fmt.Errorf("no market tickers")
+	}
+
+	sub := s.subscription(sid)
+	if sub == nil {
+		return fmt.Errorf("unknown subscription %v", sid)
+	}
+
+	r, err := s.command(ctx, command{
+		Command: "update_subscription",
+		Params: commandParams{
+			Sids:          []int{sid},
+			MarketTickers: marketTickers,
+			Action:        string(action),
+		},
+	}, sub)
+	if err != nil {
+		return err
+	}
+	if r.Type != "ok" {
+		return fmt.Errorf("unexpected message: %+v", r)
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func Test_commandParams_update(t *testing.T) {
+	t.Parallel()
+
+	p := commandParams{Channels: []string{"ticker"}, MarketTicker: "A"}
+	p = p.update(commandParams{Action: "add_markets", MarketTickers: []string{"B", "C"}})
+	require.Equal(t, commandParams{Channels: []string{"ticker"}, MarketTickers: []string{"A", "B", "C"}}, p)
+	p = p.update(commandParams{Action: "delete_markets", MarketTickers: []string{"A", "C"}})
+	require.Equal(t, []string{"B"}, p.marketTickers())
+}
+
+func TestFeedUnsubscribe(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := readCommand(ctx, t, c)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 7),
+			fmt.Sprintf(testSnapshot, 7, 40),
+		)
+
+		cmd = readCommand(ctx, t, c)
+		assert.Equal(t, "unsubscribe", cmd.Command)
+		assert.Equal(t, []int{7}, cmd.Params.Sids)
+		writeMessages(ctx, t, c, fmt.Sprintf(`{"id": %d, "type": "unsubscribed", "sid": 7}`, cmd.ID))
+		<-ctx.Done()
+	})
+
+	books := make(chan *StreamOrderBook, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- f.Book(ctx, "DUH", books)
+	}()
+	<-books
+
+	subs := f.Subscriptions()
+	require.Len(t, subs, 1)
+	require.WithinDuration(t, time.Now(), subs[0].LastMessage, time.Second)
+	subs[0].LastMessage = time.Time{}
+	require.Equal(t, Subscription{
+		Sid:           7,
+		Channel:       "orderbook_delta",
+		MarketTickers: []string{"DUH"},
+	}, subs[0])
+
+	require.NoError(t, f.Unsubscribe(ctx, 7))
+	require.NoError(t, <-errs)
+	require.Empty(t, f.Subscriptions())
+
+	require.Error(t, f.Unsubscribe(ctx, 7))
+}
+
+func TestFeedUpdateSubscription(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const (
+		snapshot = `{"type": "orderbook_snapshot", "sid": %d, "seq": %d, "msg": {"market_ticker": %q, "yes": [[40, 10]], "no": []}}`
+		ok       = `{"id": %d, "type": "ok", "sid": %d, "seq": %d, "msg": {"market_tickers": [%s]}}`
+	)
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := readCommand(ctx, t, c)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 1),
+			fmt.Sprintf(snapshot, 1, 1, "A"),
+		)
+
+		cmd = readCommand(ctx, t, c)
+		assert.Equal(t, "update_subscription", cmd.Command)
+		assert.Equal(t, commandParams{
+			Sids:          []int{1},
+			MarketTickers: []string{"B"},
+			Action:        "add_markets",
+		}, cmd.Params)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(ok, cmd.ID, 1, 2, `"A", "B"`),
+			fmt.Sprintf(snapshot, 1, 3, "B"),
+		)
+
+		cmd = readCommand(ctx, t, c)
+		assert.Equal(t, "delete_markets", cmd.Params.Action)
+		writeMessages(ctx, t, c, fmt.Sprintf(ok, cmd.ID, 1, 0, `"B"`))
+		<-ctx.Done()
+	})
+
+	events := make(chan BookEvent, 1)
+	go func() {
+		_ = f.BookEvents(ctx, "A", SnapshotEvent, events)
+	}()
+	ev := <-events
+	require.Equal(t, "A", ev.MarketID)
+
+	err := f.UpdateSubscription(ctx, 1, AddMarkets, []string{"B"})
+	require.NoError(t, err)
+	ev = <-events
+	require.Equal(t, "B", ev.MarketID)
+	require.Equal(t, 3, ev.Seq)
+
+	err = f.UpdateSubscription(ctx, 1, DeleteMarkets, []string{"A"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"B"}, f.Subscriptions()[0].MarketTickers)
+
+	require.Error(t, f.UpdateSubscription(ctx, 1, "replace", []string{"A"}))
+	require.Error(t, f.UpdateSubscription(ctx, 2, AddMarkets, []string{"A"}))
+}
+
+func TestFeedAbandonedSubscribe(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		received     = make(chan struct{})
+		abandoned    = make(chan struct{})
+		unsubscribed = make(chan []int, 1)
+	)
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := readCommand(ctx, t, c)
+		close(received)
+		// The acknowledgement arrives after the subscriber gave up.
+		<-abandoned
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 9),
+			fmt.Sprintf(testSnapshot, 9, 40),
+		)
+
+		cmd = readCommand(ctx, t, c)
+		assert.Equal(t, "unsubscribe", cmd.Command)
+		unsubscribed <- cmd.Params.Sids
+		<-ctx.Done()
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- f.Book(ctx, "DUH", make(chan *StreamOrderBook, 1))
+	}()
+	<-received
+	cancel()
+	require.ErrorIs(t, <-errs, context.Canceled)
+
+	close(abandoned)
+	require.Equal(t, []int{9}, <-unsubscribed)
+	require.Empty(t, f.Subscriptions())
+}
@@ -0,0 +1,250 @@
+package kalshi
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// VerifyPolicy configures the periodic verification of streamed books
+// against MarketOrderBook, to catch books that drifted from the exchange
+// despite sequence checks.
+//
+// Levels changed by deltas while a REST book is in flight are not compared,
+// since the REST book may have been taken before or after them.
+type VerifyPolicy struct {
+	// Interval is the time between verifications of a book. The books of a
+	// stream are verified one at a time, spread across the interval. It
+	// defaults to one minute.
+	Interval time.Duration
+	// Confirmations is the number of consecutive verifications a book must
+	// fail before it is reported. It defaults to one. Higher values tolerate
+	// a REST API that lags behind the stream.
+	Confirmations int
+	// OnDivergence, if set, is called from the verifier goroutine of a
+	// stream for every book that fails verification.
+	OnDivergence func(d BookDivergence)
+	// OnError, if set, is called from the verifier goroutine of a stream
+	// when the REST book of a market could not be fetched. The market is
+	// verified again in the next round.
+	OnError func(marketID string, err error)
+	// Resync replaces the subscription of a stream that fails verification,
+	// so that every book of the stream reloads from a fresh snapshot. A
+	// single diverged market thus costs a snapshot of every market of its
+	// stream; streams that follow many markets may prefer to leave Resync
+	// off and act on OnDivergence.
+	Resync bool
+}
+
+func (p *VerifyPolicy) interval() time.Duration {
+	if p.Interval <= 0 {
+		return time.Minute
+	}
+	return p.Interval
+}
+
+func (p *VerifyPolicy) confirmations() int {
+	if p.Confirmations <= 0 {
+		return 1
+	}
+	return p.Confirmations
+}
+
+// BookDivergence describes a streamed book that disagrees with a reference
+// book, such as the book returned by MarketOrderBook.
+type BookDivergence struct {
+	MarketID string
+	// Seq is the sequence number of the last message applied to the
+	// streamed book.
+	Seq int
+	// Levels are the levels that differ, ordered by side and price.
+	Levels []DivergentLevel
+}
+
+// DivergentLevel is a price level whose streamed quantity differs from the
+// quantity expected by a reference book, such as the REST API.
+type DivergentLevel struct {
+	Side     Side
+	Price    Cents
+	Streamed int
+	Expected int
+}
+
+// bookWatch records the changes to a StreamBook during a verification.
+type bookWatch struct {
+	// touched holds the Yes and No levels changed by deltas.
+	touched [2][100]bool
+	// reloaded is set if the book was replaced.
+	reloaded bool
+}
+
+func (w *bookWatch) touch(side Side, price Cents) {
+	if side == Yes {
+		w.touched[0][price] = true
+	} else {
+		w.touched[1][price] = true
+	}
+}
+
+// startWatch starts recording changes to the book.
+func (b *StreamBook) startWatch() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.watch = &bookWatch{}
+}
+
+// stopWatch stops recording changes and returns the book together with the
+// changes since startWatch.
+func (b *StreamBook) stopWatch() (state orderBookStreamState, seq int, w *bookWatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w, b.watch = b.watch, nil
+	return b.state, b.seq, w
+}
+
+// diffBook returns the levels where state differs from want, skipping the
+// levels touched during a verification.
+func diffBook(state *orderBookStreamState, touched *[2][100]bool, want *OrderBook) []DivergentLevel {
+	var diff []DivergentLevel
+	compare := func(i int, side Side, streamed *orderBookLevels, bids OrderBookBids) {
+		var quantity [100]int
+		for _, bid := range bids {
+			if validPrice(bid.Price) {
+				quantity[bid.Price] = bid.Quantity
+			}
+		}
+		for p := Cents(1); p <= 99; p++ {
+			if touched[i][p] || streamed.quantity[p] == quantity[p] {
+				continue
+			}
+			diff = append(diff, DivergentLevel{
+				Side:     side,
+				Price:    p,
+				Streamed: streamed.quantity[p],
+				Expected: quantity[p],
+			})
+		}
+	}
+	compare(0, Yes, &state.Yes, want.YesBids)
+	compare(1, No, &state.No, want.NoBids)
+	return diff
+}
+
+// verifyBook compares book with the REST API. It returns nil if they agree
+// or the book was replaced during the verification.
+func (s *Feed) verifyBook(ctx context.Context, book *StreamBook) (*BookDivergence, error) {
+	book.startWatch()
+	rest, err := s.client.MarketOrderBook(ctx, book.MarketID(), MarketOrderBookRequest{})
+	state, seq, w := book.stopWatch()
+	if err != nil {
+		return nil, err
+	}
+	if w.reloaded || seq == 0 {
+		return nil, nil
+	}
+
+	diff := diffBook(&state, &w.touched, rest)
+	if len(diff) == 0 {
+		return nil, nil
+	}
+	return &BookDivergence{
+		MarketID: book.MarketID(),
+		Seq:      seq,
+		Levels:   diff,
+	}, nil
+}
+
+// verifyBooks periodically verifies the books of a stream until ctx is
+// done. books lists the books of the stream in market order, and ref
+// follows its subscription.
+//
+// Verifications are spread evenly across the interval, one book at a time,
+// and wait for the read rate limit of the Client, so that streams of many
+// books don't fail in bursts. Every round starts after the last book that
+// was verified, so all books are covered even if a round is cut short.
+func (s *Feed) verifyBooks(ctx context.Context, p VerifyPolicy, ref *streamRef, books func() []*StreamBook) {
+	restCtx := waitRateLimit(ctx)
+
+	// failures counts the consecutive failed verifications by market.
+	failures := make(map[string]int)
+	// last is the market verified last.
+	var last string
+	for {
+		list := books()
+		wait := p.interval()
+		if len(list) > 0 {
+			wait /= time.Duration(len(list))
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+		}
+		if len(list) == 0 {
+			continue
+		}
+
+		book := nextBook(list, last)
+		last = book.MarketID()
+		d, err := s.verifyBook(restCtx, book)
+		if err != nil {
+			// The book is verified again in the next round.
+			if ctx.Err() == nil && p.OnError != nil {
+				p.OnError(book.MarketID(), err)
+			}
+			continue
+		}
+		if d == nil {
+			delete(failures, book.MarketID())
+			continue
+		}
+		failures[d.MarketID]++
+		if failures[d.MarketID] < p.confirmations() {
+			continue
+		}
+		delete(failures, d.MarketID)
+
+		if p.OnDivergence != nil {
+			p.OnDivergence(*d)
+		}
+		if p.Resync {
+			s.mu.Lock()
+			sub := ref.sub
+			s.mu.Unlock()
+			if sub != nil {
+				s.endSubscription(sub, errResync)
+			}
+			// Every book reloads.
+			failures = make(map[string]int)
+		}
+	}
+}
+
+// nextBook returns the first book of list, which is in market order, after
+// the market last. It wraps around to the first book.
+func nextBook(list []*StreamBook, last string) *StreamBook {
+	i := sort.Search(len(list), func(i int) bool {
+		return list[i].MarketID() > last
+	})
+	if i == len(list) {
+		i = 0
+	}
+	return list[i]
+}
+
+// sortedBooks returns the books of books under mu in market order.
+func sortedBooks(mu *sync.Mutex, books map[string]*StreamBook) []*StreamBook {
+	mu.Lock()
+	defer mu.Unlock()
+	list := make([]*StreamBook, 0, len(books))
+	for _, book := range books {
+		list = append(list, book)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].MarketID() < list[j].MarketID()
+	})
+	return list
+}
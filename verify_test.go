@@ -0,0 +1,202 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+	"nhooyr.io/websocket"
+)
+
+func Test_diffBook(t *testing.T) {
+	t.Parallel()
+
+	state := makeOrderBookStreamState("DUH")
+	require.NoError(t, state.LoadBook(OrderBook{
+		YesBids: OrderBookBids{{40, 10}, {41, 5}},
+		NoBids:  OrderBookBids{{50, 10}},
+	}))
+
+	rest := &OrderBook{
+		YesBids: OrderBookBids{{40, 10}, {42, 5}},
+		NoBids:  OrderBookBids{{50, 7}, {51, 1}},
+	}
+	var touched [2][100]bool
+	require.Equal(t, []DivergentLevel{
+		{Side: Yes, Price: 41, Streamed: 5, Expected: 0},
+		{Side: Yes, Price: 42, Streamed: 0, Expected: 5},
+		{Side: No, Price: 50, Streamed: 10, Expected: 7},
+		{Side: No, Price: 51, Streamed: 0, Expected: 1},
+	}, diffBook(&state, &touched, rest))
+
+	// Levels changed while the REST book was in flight are not compared.
+	touched[0][41] = true
+	touched[1][50] = true
+	require.Equal(t, []DivergentLevel{
+		{Side: Yes, Price: 42, Streamed: 0, Expected: 5},
+		{Side: No, Price: 51, Streamed: 0, Expected: 1},
+	}, diffBook(&state, &touched, rest))
+
+	require.Empty(t, diffBook(&state, &touched, &OrderBook{
+		YesBids: OrderBookBids{{40, 10}, {41, 1}},
+		NoBids:  OrderBookBids{{50, 3}},
+	}))
+}
+
+func TestFeedVerify(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		assert.Equal(t, "/markets/DUH/orderbook", r.URL.Path)
+		_, _ = w.Write([]byte(`{"orderbook": {"yes": [[41, 10]], "no": null}}`))
+	}))
+	defer srv.Close()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		// The first snapshot drifted from the exchange.
+		cmd := readCommand(ctx, t, c)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 1),
+			fmt.Sprintf(testSnapshot, 1, 40),
+		)
+
+		cmd = readCommand(ctx, t, c)
+		assert.Equal(t, "unsubscribe", cmd.Command)
+		cmd = readCommand(ctx, t, c)
+		assert.Equal(t, "subscribe", cmd.Command)
+		writeMessages(ctx, t, c,
+			fmt.Sprintf(testSubscribed, cmd.ID, 2),
+			fmt.Sprintf(testSnapshot, 2, 41),
+		)
+		<-ctx.Done()
+	})
+	f.client = New(srv.URL + "/")
+	divergences := make(chan BookDivergence, 4)
+	f.Verify = &VerifyPolicy{
+		Interval:      time.Millisecond * 10,
+		Confirmations: 2,
+		Resync:        true,
+		OnDivergence: func(d BookDivergence) {
+			divergences <- d
+		},
+	}
+
+	books := make(chan *StreamOrderBook, 4)
+	go func() {
+		_ = f.Book(ctx, "DUH", books)
+	}()
+	require.Equal(t, OrderBookBids{{40, 10}}, (<-books).YesBids)
+
+	d := <-divergences
+	require.Equal(t, BookDivergence{
+		MarketID: "DUH",
+		Seq:      1,
+		Levels: []DivergentLevel{
+			{Side: Yes, Price: 40, Streamed: 10, Expected: 0},
+			{Side: Yes, Price: 41, Streamed: 0, Expected: 10},
+		},
+	}, d)
+	// The divergence was confirmed before it was reported.
+	require.GreaterOrEqual(t, requests.Load(), int64(2))
+
+	// The stream resyncs and agrees with the exchange from then on.
+	require.Equal(t, OrderBookBids{{41, 10}}, (<-books).YesBids)
+	n := requests.Load()
+	require.Eventually(t, func() bool {
+		return requests.Load() > n+2
+	}, time.Second*5, time.Millisecond*5)
+	require.Empty(t, divergences)
+}
+
+func TestFeedVerifyManyBooks(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const markets = 12
+	tickers := make([]string, markets)
+	for i := range tickers {
+		tickers[i] = fmt.Sprintf("M%02d", i)
+	}
+
+	var (
+		mu       sync.Mutex
+		requests = make(map[string]int)
+		failed   = make(map[string]int)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ticker := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/markets/"), "/orderbook")
+		mu.Lock()
+		requests[ticker]++
+		mu.Unlock()
+		if ticker == "M05" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"orderbook": {"yes": [[40, 10]], "no": null}}`))
+	}))
+	defer srv.Close()
+
+	f := testFeed(t, func(ctx context.Context, c *websocket.Conn) {
+		cmd := readCommand(ctx, t, c)
+		msgs := []string{fmt.Sprintf(testSubscribed, cmd.ID, 1)}
+		for i, ticker := range tickers {
+			msgs = append(msgs, fmt.Sprintf(
+				`{"type": "orderbook_snapshot", "sid": 1, "seq": %d, "msg": {"market_ticker": %q, "yes": [[40, 10]], "no": []}}`,
+				i+1, ticker,
+			))
+		}
+		writeMessages(ctx, t, c, msgs...)
+		<-ctx.Done()
+	})
+	f.client = New(srv.URL + "/")
+	// Books are verified faster than the rate limit refills.
+	f.client.ReadRateLimit = rate.NewLimiter(rate.Every(time.Millisecond*5), 1)
+	f.Verify = &VerifyPolicy{
+		Interval: time.Millisecond * 36,
+		OnDivergence: func(d BookDivergence) {
+			t.Errorf("unexpected divergence %+v", d)
+		},
+		OnError: func(marketID string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failed[marketID]++
+		},
+	}
+
+	books := make(chan *StreamOrderBook, markets)
+	go func() {
+		_ = f.Books(ctx, tickers, books)
+	}()
+
+	// Every book is verified in turn, not just the first burst, and only
+	// the failed market is reported.
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ticker := range tickers {
+			if requests[ticker] < 2 {
+				return false
+			}
+		}
+		return true
+	}, time.Second*5, time.Millisecond*5)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, failed, 1)
+	require.Positive(t, failed["M05"])
+}
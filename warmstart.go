@@ -0,0 +1,168 @@
+package kalshi
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// BookState is a snapshot of the streamed books of a Feed. Save it on
+// shutdown and pass it to the WarmStart policy of the next Feed, so that
+// books are available before the exchange sends fresh snapshots.
+type BookState struct {
+	SavedAt time.Time   `json:"saved_at"`
+	Books   []SavedBook `json:"books"`
+}
+
+// SavedBook is a streamed book in a BookState.
+type SavedBook struct {
+	MarketID string        `json:"market_id"`
+	YesBids  OrderBookBids `json:"yes_bids"`
+	NoBids   OrderBookBids `json:"no_bids"`
+}
+
+// WarmStartPolicy preloads streamed books from a saved BookState.
+//
+// Saved books are sent as a SnapshotEvent with a Seq of zero as soon as the
+// exchange acknowledges the subscription of their stream, before any fresh
+// snapshot arrives, and are validated against the fresh snapshot once it
+// does. The fresh snapshot is only sent to
+// consumers if it differs from the saved book.
+type WarmStartPolicy struct {
+	State *BookState
+	// MaxAge discards the state if it was saved longer ago. It defaults to
+	// one minute.
+	MaxAge time.Duration
+	// OnStale, if set, is called from the reader goroutine for every saved
+	// book that differs from its fresh snapshot.
+	OnStale func(d BookDivergence)
+}
+
+func (p *WarmStartPolicy) maxAge() time.Duration {
+	if p.MaxAge <= 0 {
+		return time.Minute
+	}
+	return p.MaxAge
+}
+
+// books returns the saved books by market, or nil if the state is stale.
+func (p *WarmStartPolicy) books(now time.Time) map[string]SavedBook {
+	if p.State == nil || now.Sub(p.State.SavedAt) > p.maxAge() {
+		return nil
+	}
+	books := make(map[string]SavedBook, len(p.State.Books))
+	for _, book := range p.State.Books {
+		books[book.MarketID] = book
+	}
+	return books
+}
+
+// bookStream lists the books of a stream for BookState.
+type bookStream struct {
+	books func() []*StreamBook
+}
+
+// BookState returns the books currently streamed by the Feed. Books that
+// have not been loaded are left out.
+func (s *Feed) BookState() *BookState {
+	s.mu.Lock()
+	streams := make([]*bookStream, 0, len(s.bookStreams))
+	for bs := range s.bookStreams {
+		streams = append(streams, bs)
+	}
+	s.mu.Unlock()
+
+	st := &BookState{SavedAt: time.Now()}
+	for _, bs := range streams {
+		for _, book := range bs.books() {
+			state, seq := book.snapshot()
+			if seq == 0 {
+				continue
+			}
+			st.Books = append(st.Books, SavedBook{
+				MarketID: state.MarketID,
+				YesBids:  state.Yes.bids(),
+				NoBids:   state.No.bids(),
+			})
+		}
+	}
+	sort.Slice(st.Books, func(i, j int) bool {
+		return st.Books[i].MarketID < st.Books[j].MarketID
+	})
+	return st
+}
+
+// Save writes the state to path. The file is synced to disk and replaced
+// atomically, so a crash during Save leaves the previous state intact.
+func (st *BookState) Save(path string) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = json.NewEncoder(tmp).Encode(st)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("encode: %w", err)
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync: %w", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return err
+	}
+	// The rename is only durable once the directory is synced.
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		// Directories can't be synced on Windows.
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err != nil {
+		d.Close()
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	return d.Close()
+}
+
+// LoadBookState reads a state written by BookState.Save.
+func LoadBookState(path string) (*BookState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var st BookState
+	err = json.Unmarshal(b, &st)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %w", path, err)
+	}
+	return &st, nil
+}
+
+// snapshot returns a copy of the book and the sequence number of the last
+// message applied to it.
+func (b *StreamBook) snapshot() (orderBookStreamState, int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.state, b.seq
+}
@@ -0,0 +1,155 @@
+package kalshi
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"nhooyr.io/websocket"
+)
+
+func TestBookState(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "books.json")
+	st := &BookState{
+		SavedAt: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
+		Books: []SavedBook{{
+			MarketID: "A",
+			YesBids:  OrderBookBids{{40, 10}},
+		}},
+	}
+	require.NoError(t, st.Save(path))
+	require.NoError(t, st.Save(path))
+
+	got, err := LoadBookState(path)
+	require.NoError(t, err)
+	require.Equal(t, st, got)
+
+	// Temporary files are cleaned up.
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	_, err = LoadBookState(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
+
+func TestFeedWarmStart(t *testing.T) {
+	t.Parallel()
+
+	const snapshot = `{"type": "orderbook_snapshot", "sid": 1, "seq": %d, "msg": {"market_ticker": %q, "yes": [[%d, 10]], "no": []}}`
+
+	saved := &BookState{
+		SavedAt: time.Now(),
+		Books: []SavedBook{
+			{MarketID: "A", YesBids: OrderBookBids{{40, 10}}},
+			{MarketID: "B", YesBids: OrderBookBids{{40, 10}}},
+		},
+	}
+	// serve acknowledges the subscription, and sends the fresh snapshots
+	// once ready is closed.
+	serve := func(t *testing.T, ready <-chan struct{}) func(ctx context.Context, c *websocket.Conn) {
+		return func(ctx context.Context, c *websocket.Conn) {
+			cmd := readCommand(ctx, t, c)
+			writeMessages(ctx, t, c, fmt.Sprintf(testSubscribed, cmd.ID, 1))
+			select {
+			case <-ready:
+			case <-ctx.Done():
+				return
+			}
+			writeMessages(ctx, t, c,
+				fmt.Sprintf(snapshot, 1, "A", 40),
+				fmt.Sprintf(snapshot, 2, "B", 41),
+				fmt.Sprintf(snapshot, 3, "C", 42),
+			)
+			<-ctx.Done()
+		}
+	}
+	// event captures a snapshot event, since its book changes once the
+	// reader moves on.
+	type event struct {
+		MarketID string
+		Seq      int
+		Yes      OrderBookBids
+	}
+	handle := func(ctx context.Context, f *Feed, events chan<- event) {
+		go func() {
+			_ = f.Handle(ctx, FeedHandlerFuncs{
+				Snapshot: func(ev BookEvent) {
+					events <- event{ev.MarketID, ev.Seq, ev.Book.OrderBook().YesBids}
+				},
+			}, HandlerSubscription{MarketTickers: []string{"A", "B", "C"}, Books: true})
+		}()
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ready := make(chan struct{})
+		f := testFeed(t, serve(t, ready))
+		stale := make(chan BookDivergence, 2)
+		f.WarmStart = &WarmStartPolicy{
+			State: saved,
+			OnStale: func(d BookDivergence) {
+				stale <- d
+			},
+		}
+		events := make(chan event, 8)
+		handle(ctx, f, events)
+
+		// Saved books are sent as soon as the subscription is
+		// acknowledged, before any fresh snapshot. The fresh snapshot of A
+		// matches, so only B and C follow.
+		require.Equal(t, event{"A", 0, OrderBookBids{{40, 10}}}, <-events)
+		require.Equal(t, event{"B", 0, OrderBookBids{{40, 10}}}, <-events)
+		close(ready)
+		require.Equal(t, event{"B", 2, OrderBookBids{{41, 10}}}, <-events)
+		require.Equal(t, event{"C", 3, OrderBookBids{{42, 10}}}, <-events)
+
+		require.Equal(t, BookDivergence{
+			MarketID: "B",
+			Levels: []DivergentLevel{
+				{Side: Yes, Price: 40, Streamed: 10, Expected: 0},
+				{Side: Yes, Price: 41, Streamed: 0, Expected: 10},
+			},
+		}, <-stale)
+		require.Empty(t, stale)
+
+		st := f.BookState()
+		require.WithinDuration(t, time.Now(), st.SavedAt, time.Second)
+		require.Equal(t, []SavedBook{
+			{MarketID: "A", YesBids: OrderBookBids{{40, 10}}},
+			{MarketID: "B", YesBids: OrderBookBids{{41, 10}}},
+			{MarketID: "C", YesBids: OrderBookBids{{42, 10}}},
+		}, st.Books)
+	})
+
+	t.Run("Stale", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		ready := make(chan struct{})
+		close(ready)
+		f := testFeed(t, serve(t, ready))
+		f.WarmStart = &WarmStartPolicy{
+			State: &BookState{
+				SavedAt: saved.SavedAt.Add(-time.Minute * 2),
+				Books:   saved.Books,
+			},
+		}
+		events := make(chan event, 8)
+		handle(ctx, f, events)
+
+		require.Equal(t, event{"A", 1, OrderBookBids{{40, 10}}}, <-events)
+		require.Equal(t, event{"B", 2, OrderBookBids{{41, 10}}}, <-events)
+		require.Equal(t, event{"C", 3, OrderBookBids{{42, 10}}}, <-events)
+	})
+}